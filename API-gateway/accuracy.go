@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/afex/hystrix-go/hystrix"
+	"github.com/robfig/cron/v3"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cache keys of the forecast snapshots, the matches waiting for their weather history and the accuracy totals.
+// A match's details are under forecast_snapshots_<uid>, and each lead time's snapshot under its own
+// forecast_snapshots_<uid>_<lead> key.
+const (
+	forecastSnapshotPrefix       = "forecast_snapshots_"
+	forecastAccuracyQueuedPrefix = "forecast_accuracy_queued_"
+	forecastAccuracyPendingKey   = "forecast_accuracy_pending"
+	forecastAccuracyStatsKey     = "forecast_accuracy_stats"
+	forecastAccuracyLockKey      = "forecast_accuracy_leader"
+)
+
+// forecastSnapshotTTL keeps snapshots long enough for matches weeks away to be played and evaluated.
+// Snapshots of matches that are never evaluated expire with it.
+const forecastSnapshotTTL = 60 * 24 * time.Hour
+
+// rainChanceThreshold is the chance of rain from which a forecast counts as predicting rain,
+// and a history hour as having had rain
+const rainChanceThreshold = 50
+
+// ForecastSnapshot is the faceoff forecast a match was served with, some days before it was played
+type ForecastSnapshot struct {
+	RecordedAt      time.Time `json:"recorded_at"`
+	TempC           float64   `json:"temp_c"`
+	MaxChanceOfRain int       `json:"max_chance_of_rain"`
+	Condition       string    `json:"condition"`
+}
+
+// forecastSnapshots holds the latest snapshot of a match for every lead time, in days before the match
+type forecastSnapshots struct {
+	UID       string                   `json:"uid"`
+	City      string                   `json:"city"`
+	Location  string                   `json:"location"`
+	Date      string                   `json:"date"`    // Local match date, dd.mm.yyyy
+	Faceoff   string                   `json:"faceoff"` // RFC 3339
+	Timezone  string                   `json:"timezone"`
+	Estimated bool                     `json:"estimated"`
+	ByLead    map[int]ForecastSnapshot `json:"-"` // Read from the keys of the lead times
+}
+
+// forecastSnapshotKey is the cache key of a match's snapshot for one lead time
+func forecastSnapshotKey(uid string, lead int) string {
+	return forecastSnapshotPrefix + uid + "_" + strconv.Itoa(lead)
+}
+
+// forecastSnapshotKeys returns every key recorded for a match: its details, its snapshot for every lead
+// time a forecast exists for, and the marker that it is queued for evaluation
+func (g *Gateway) forecastSnapshotKeys(uid string) []string {
+	keys := []string{forecastSnapshotPrefix + uid, forecastAccuracyQueuedPrefix + uid}
+	for lead := 0; lead <= g.config.ForecastHorizonDays; lead++ {
+		keys = append(keys, forecastSnapshotKey(uid, lead))
+	}
+	return keys
+}
+
+// accuracyTotals accumulates the errors of the snapshots of one lead time
+type accuracyTotals struct {
+	Samples      int     `json:"samples"`
+	TempErrorSum float64 `json:"temp_error_sum"`
+	RainHits     int     `json:"rain_hits"`
+}
+
+// accuracyStats holds the totals by city, then by lead time
+type accuracyStats map[string]map[int]*accuracyTotals
+
+// add records the errors of one snapshot
+func (s accuracyStats) add(city string, lead int, tempError float64, rainHit bool) {
+	if s[city] == nil {
+		s[city] = make(map[int]*accuracyTotals)
+	}
+	totals := s[city][lead]
+	if totals == nil {
+		totals = &accuracyTotals{}
+		s[city][lead] = totals
+	}
+	totals.Samples++
+	totals.TempErrorSum += tempError
+	if rainHit {
+		totals.RainHits++
+	}
+}
+
+// LeadTimeAccuracy is how good the forecasts made some days before the matches were
+type LeadTimeAccuracy struct {
+	LeadDays    int     `json:"lead_days"`
+	Samples     int     `json:"samples"`
+	TempMAE     float64 `json:"temp_mae_c"`    // Mean absolute error of the faceoff temperature
+	RainHitRate float64 `json:"rain_hit_rate"` // Share of matches where rain was correctly predicted or ruled out
+}
+
+// CityAccuracy is the accuracy of the forecasts for the matches in one city
+type CityAccuracy struct {
+	City       string             `json:"city"`
+	ByLeadTime []LeadTimeAccuracy `json:"by_lead_time"`
+}
+
+// ForecastAccuracyReport compares the forecasts served for matches with the weather history of the matches
+type ForecastAccuracyReport struct {
+	ByLeadTime []LeadTimeAccuracy `json:"by_lead_time"`
+	ByCity     []CityAccuracy     `json:"by_city"`
+}
+
+// recordForecastSnapshot stores the faceoff forecast served for a match under its lead time.
+// Every lead time has its own key, so concurrent aggregations replace only their own lead time's snapshot.
+// Matches seen for the first time are queued for evaluation once they are played. Nothing is recorded while
+// the clock is pinned, so replays of other days don't count towards the accuracy.
+func (g *Gateway) recordForecastSnapshot(ctx context.Context, match Match, location string, start faceoff, atFaceoff *FaceoffWeather) {
+	queue := g.cacheQueue()
+	locker, ok := g.cache.(Locker)
+	if queue == nil || !ok || g.config.ForecastAccuracySchedule == "" || match.UID == "" || atFaceoff == nil || g.clockPinned(ctx) {
+		return
+	}
+
+	now := g.now(ctx).In(start.at.Location())
+	today, _ := time.Parse(matchDateLayout, now.Format(matchDateLayout))
+	matchDay, _ := time.Parse(matchDateLayout, start.date())
+	lead := int(matchDay.Sub(today).Hours() / 24)
+	if lead < 0 || lead > g.config.ForecastHorizonDays {
+		return
+	}
+
+	// The match details are overwritten, so a rescheduled match is evaluated at its new date and venue
+	details, err := json.Marshal(forecastSnapshots{
+		UID:       match.UID,
+		City:      match.City,
+		Location:  location,
+		Date:      start.date(),
+		Faceoff:   atFaceoff.Faceoff,
+		Timezone:  atFaceoff.Timezone,
+		Estimated: start.estimated,
+	})
+	if err != nil {
+		return
+	}
+	snapshot, err := json.Marshal(ForecastSnapshot{
+		RecordedAt:      now.UTC(),
+		TempC:           atFaceoff.TempC,
+		MaxChanceOfRain: atFaceoff.MaxChanceOfRain,
+		Condition:       atFaceoff.Condition,
+	})
+	if err != nil {
+		return
+	}
+	if err := g.cache.Set(ctx, forecastSnapshotPrefix+match.UID, string(details), forecastSnapshotTTL); err != nil {
+		return
+	}
+	if err := g.cache.Set(ctx, forecastSnapshotKey(match.UID, lead), string(snapshot), forecastSnapshotTTL); err != nil {
+		return
+	}
+
+	// Only the first snapshot of a match queues it
+	if queued, err := locker.SetNX(ctx, forecastAccuracyQueuedPrefix+match.UID, "1", forecastSnapshotTTL); err == nil && queued {
+		queue.Push(ctx, forecastAccuracyPendingKey, match.UID)
+	}
+}
+
+// loadForecastSnapshots reads a match's details and its snapshots, or returns errCacheMiss when it has none
+func (g *Gateway) loadForecastSnapshots(ctx context.Context, uid string) (forecastSnapshots, error) {
+	var snapshots forecastSnapshots
+	keys := g.forecastSnapshotKeys(uid)
+	stored, err := g.cache.MGet(ctx, keys...)
+	if err != nil {
+		return snapshots, err
+	}
+	if stored[0] == "" {
+		return snapshots, errCacheMiss
+	}
+	if err := json.Unmarshal([]byte(stored[0]), &snapshots); err != nil {
+		return snapshots, err
+	}
+
+	// The details and the marker come before the lead times
+	snapshots.ByLead = make(map[int]ForecastSnapshot)
+	for lead, entry := range stored[2:] {
+		var snapshot ForecastSnapshot
+		if entry != "" && json.Unmarshal([]byte(entry), &snapshot) == nil {
+			snapshots.ByLead[lead] = snapshot
+		}
+	}
+	return snapshots, nil
+}
+
+// startForecastAccuracyJob schedules the comparison of the snapshots of played matches with their weather history
+func (g *Gateway) startForecastAccuracyJob() {
+	if g.config.ForecastAccuracySchedule == "" || g.cacheQueue() == nil {
+		return
+	}
+
+	scheduler := cron.New()
+	_, err := scheduler.AddFunc(g.config.ForecastAccuracySchedule, func() {
+		// Only one gateway evaluates the matches, so every snapshot is counted once
+		if !g.acquireLeaderLock(context.Background(), forecastAccuracyLockKey, g.config.ForecastAccuracyLockTTL) {
+			return
+		}
+		g.evaluateForecasts(context.Background())
+	})
+	if err != nil {
+		fmt.Println("Error scheduling the forecast accuracy job:", err)
+		return
+	}
+
+	scheduler.Start()
+	fmt.Println("Forecast accuracy job scheduled:", g.config.ForecastAccuracySchedule)
+}
+
+// evaluateForecasts compares the snapshots of every match played before today with its weather history.
+// Matches whose history can't be fetched yet stay pending for the next run.
+func (g *Gateway) evaluateForecasts(ctx context.Context) {
+	queue := g.cacheQueue()
+	uids, err := queue.Range(ctx, forecastAccuracyPendingKey)
+	if err != nil {
+		fmt.Println("Error reading the matches pending forecast evaluation:", err)
+		return
+	}
+
+	stats := g.forecastAccuracyStats(ctx)
+	var evaluated []string
+	seen := make(map[string]bool)
+	for _, uid := range uids {
+		// A match whose snapshots were dropped and recorded again is queued twice, but counted once
+		if seen[uid] {
+			continue
+		}
+		seen[uid] = true
+
+		snapshots, err := g.loadForecastSnapshots(ctx, uid)
+		if err == errCacheMiss {
+			queue.Remove(ctx, forecastAccuracyPendingKey, uid)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		done, err := g.evaluateMatchForecasts(ctx, snapshots, stats)
+		if err != nil {
+			fmt.Println("Error evaluating the forecasts of match", uid+":", err)
+			continue
+		}
+		if done {
+			evaluated = append(evaluated, uid)
+		}
+	}
+
+	if len(evaluated) == 0 {
+		return
+	}
+	// The totals are saved before the matches leave the pending list, so no evaluation is lost
+	body, err := json.Marshal(stats)
+	if err == nil {
+		err = g.cache.Set(ctx, forecastAccuracyStatsKey, string(body), 0)
+	}
+	if err != nil {
+		fmt.Println("Error saving the forecast accuracy:", err)
+		return
+	}
+	for _, uid := range evaluated {
+		queue.Remove(ctx, forecastAccuracyPendingKey, uid)
+		g.cache.Delete(ctx, g.forecastSnapshotKeys(uid)...)
+	}
+	fmt.Printf("Forecast accuracy: %d matches evaluated\n", len(evaluated))
+}
+
+// evaluateMatchForecasts adds the errors of a match's snapshots to the stats. It returns false
+// when the match has not been played yet.
+func (g *Gateway) evaluateMatchForecasts(ctx context.Context, snapshots forecastSnapshots, stats accuracyStats) (bool, error) {
+	at, err := time.Parse(time.RFC3339, snapshots.Faceoff)
+	if err != nil {
+		return false, err
+	}
+	if location, err := time.LoadLocation(snapshots.Timezone); err == nil {
+		at = at.In(location)
+	}
+	start := faceoff{at: at, estimated: snapshots.Estimated}
+
+	// The history is only complete once the match day is over
+	if start.date() == g.now(ctx).In(at.Location()).Format(matchDateLayout) || at.After(g.now(ctx)) {
+		return false, nil
+	}
+
+	hystrix.ConfigureCommand("getWeatherHistory", hystrix.CommandConfig{
+		Timeout:               10000, // Timeout in milliseconds
+		MaxConcurrentRequests: 100,   // Maximum number of concurrent requests
+		ErrorPercentThreshold: 25,    // Error percentage threshold for circuit breaker
+	})
+	// The same cache key as getWeatherHistory
+	body, err := g.cachedUpstream(ctx, "weather_history_"+snapshots.Location+"_"+start.date(), "getWeatherHistory",
+		RoundRobinBalancer()+"/weather_history?"+url.Values{"location": {snapshots.Location}, "date": {start.date()}}.Encode())
+	if err != nil {
+		return false, err
+	}
+	var history WeatherHistoryResponse
+	if err := decodeWeather(body, &history); err != nil {
+		return false, err
+	}
+
+	window, actual := g.faceoffWindow(start, history.HourlyWeather)
+	if actual == nil {
+		return false, fmt.Errorf("no weather history around faceoff")
+	}
+	rained := rainObserved(window)
+
+	for lead, snapshot := range snapshots.ByLead {
+		predictedRain := snapshot.MaxChanceOfRain >= rainChanceThreshold
+		stats.add(snapshots.City, lead, math.Abs(snapshot.TempC-actual.TempC), predictedRain == rained)
+	}
+	return true, nil
+}
+
+// rainObserved reports whether any hour of a history window had rain
+func rainObserved(window map[string]HourlyWeather) bool {
+	for _, weather := range window {
+		condition := strings.ToLower(weather.Condition)
+		if weather.ChanceOfRain >= rainChanceThreshold || strings.Contains(condition, "rain") ||
+			strings.Contains(condition, "drizzle") || strings.Contains(condition, "shower") {
+			return true
+		}
+	}
+	return false
+}
+
+// forecastAccuracyStats returns the accumulated accuracy totals
+func (g *Gateway) forecastAccuracyStats(ctx context.Context) accuracyStats {
+	stats := make(accuracyStats)
+	if stored, err := g.cache.Get(ctx, forecastAccuracyStatsKey); err == nil {
+		json.Unmarshal([]byte(stored), &stats)
+	}
+	return stats
+}
+
+// leadTimeAccuracy turns totals by lead time into a report sorted by lead time
+func leadTimeAccuracy(byLead map[int]*accuracyTotals) []LeadTimeAccuracy {
+	report := []LeadTimeAccuracy{}
+	for lead, totals := range byLead {
+		if totals.Samples == 0 {
+			continue
+		}
+		report = append(report, LeadTimeAccuracy{
+			LeadDays:    lead,
+			Samples:     totals.Samples,
+			TempMAE:     math.Round(totals.TempErrorSum/float64(totals.Samples)*100) / 100,
+			RainHitRate: math.Round(float64(totals.RainHits)/float64(totals.Samples)*1000) / 1000,
+		})
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].LeadDays < report[j].LeadDays })
+	return report
+}
+
+// getForecastAccuracy reports the forecast error by lead time, overall and by city. The city parameter
+// limits the report to one city.
+func (g *Gateway) getForecastAccuracy(w http.ResponseWriter, r *http.Request) {
+	city := r.URL.Query().Get("city")
+	stats := g.forecastAccuracyStats(r.Context())
+
+	overall := make(map[int]*accuracyTotals)
+	report := ForecastAccuracyReport{ByCity: []CityAccuracy{}}
+	for name, byLead := range stats {
+		if city != "" && !strings.EqualFold(normalizeCity(name), normalizeCity(city)) {
+			continue
+		}
+		report.ByCity = append(report.ByCity, CityAccuracy{City: name, ByLeadTime: leadTimeAccuracy(byLead)})
+
+		for lead, totals := range byLead {
+			if overall[lead] == nil {
+				overall[lead] = &accuracyTotals{}
+			}
+			overall[lead].Samples += totals.Samples
+			overall[lead].TempErrorSum += totals.TempErrorSum
+			overall[lead].RainHits += totals.RainHits
+		}
+	}
+	report.ByLeadTime = leadTimeAccuracy(overall)
+	sort.Slice(report.ByCity, func(i, j int) bool { return report.ByCity[i].City < report.ByCity[j].City })
+
+	writeJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// weatherCommandMaxConcurrency is the MaxConcurrentRequests of the Hystrix commands used by the aggregations
+// to call the weather microservice. The fan-out never runs more workers than that, or Hystrix would reject them.
+const weatherCommandMaxConcurrency = 10
+
+// weatherCommandSlots holds a semaphore of weatherCommandMaxConcurrency slots per Hystrix command fetchWeather
+// calls. Hystrix limits a command's concurrent requests across the whole gateway, so aggregations, the warmer,
+// the live feeds and the jobs wait for a slot instead of being rejected with ErrMaxConcurrency.
+var (
+	weatherCommandSlotsMu sync.Mutex
+	weatherCommandSlots   = make(map[string]chan struct{})
+)
+
+// acquireWeatherSlot waits for a slot of a command and returns the function that frees it,
+// or the context's error if it is cancelled first
+func acquireWeatherSlot(ctx context.Context, command string) (func(), error) {
+	weatherCommandSlotsMu.Lock()
+	slots, ok := weatherCommandSlots[command]
+	if !ok {
+		slots = make(chan struct{}, weatherCommandMaxConcurrency)
+		weatherCommandSlots[command] = slots
+	}
+	weatherCommandSlotsMu.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// aggregationWorkers returns how many weather lookups an aggregation may run at the same time
+func (g *Gateway) aggregationWorkers() int {
+	workers := g.config.AggregationWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > weatherCommandMaxConcurrency {
+		workers = weatherCommandMaxConcurrency
+	}
+	return workers
+}
+
+// fanOutResult carries the result of one fan-out item back to the collecting goroutine
+type fanOutResult[T any] struct {
+	index int
+	value T
+}
+
+// fanOut calls fn for every index in [0, n) using at most limit goroutines at a time and returns the results
+// in input order. Results travel back over a channel, so the workers never write to memory the caller reads.
+// fn receives ctx and should stop early once it is cancelled.
+func fanOut[T any](ctx context.Context, n int, limit int, fn func(ctx context.Context, i int) T) []T {
+	values := make([]T, n)
+	fanOutEach(ctx, n, limit, fn, func(i int, value T) {
+		values[i] = value
+	})
+	return values
+}
+
+// fanOutEach works like fanOut but hands every result to done as soon as it arrives, in completion order.
+// done runs on the calling goroutine, so it may write to the response.
+func fanOutEach[T any](ctx context.Context, n int, limit int, fn func(ctx context.Context, i int) T, done func(i int, value T)) {
+	if limit < 1 {
+		limit = 1
+	}
+	if limit > n {
+		limit = n
+	}
+
+	indexes := make(chan int, n)
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+
+	results := make(chan fanOutResult[T], n)
+	for worker := 0; worker < limit; worker++ {
+		go func() {
+			for i := range indexes {
+				results <- fanOutResult[T]{index: i, value: fn(ctx, i)}
+			}
+		}()
+	}
+
+	for received := 0; received < n; received++ {
+		result := <-results
+		done(result.index, result.value)
+	}
+}
+
+// fetchWeather gets a weather microservice URL under the given Hystrix command and returns the response body
+func fetchWeather(ctx context.Context, command string, weatherURL string) ([]byte, error) {
+	release, err := acquireWeatherSlot(ctx, command)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	status, body, err := callUpstream(ctx, command, weatherURL)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("weather microservice returned status %d", status)
+	}
+	return body, nil
+}
+
+// decodeWeather parses a weather microservice response, logging the body if it is not valid
+func decodeWeather(body []byte, target interface{}) error {
+	if err := json.Unmarshal(body, target); err != nil {
+		fmt.Printf("Error parsing weather response: %v\n", err)
+		fmt.Printf("Response body: %s\n", string(body))
+		return err
+	}
+	return nil
+}
+
+// weatherLookups collects the unique weather microservice calls an aggregation needs.
+// Matches sharing a location and date share a single lookup.
+type weatherLookups struct {
+	keys  []string // Per-route cache key of each lookup
+	paths []string // Weather microservice path and query of each lookup
+	index map[string]int
+
+	ttl     time.Duration            // How long fetched bodies are cached, an hour when zero
+	fetched func(i int, body []byte) // Called from the fetching goroutine with every body fetched, if set
+}
+
+// add registers a lookup and returns its index; a lookup with an already known cache key is not added again
+func (l *weatherLookups) add(cacheKey string, path string) int {
+	if l.index == nil {
+		l.index = make(map[string]int)
+	}
+	if i, ok := l.index[cacheKey]; ok {
+		return i
+	}
+
+	l.index[cacheKey] = len(l.keys)
+	l.keys = append(l.keys, cacheKey)
+	l.paths = append(l.paths, path)
+	return len(l.keys) - 1
+}
+
+// weatherFetch is the outcome of one weather microservice call made by resolveWeatherLookups
+type weatherFetch struct {
+	body []byte
+	err  error
+}
+
+// resolveWeatherLookups returns the response body of every lookup. It reads all of them from the per-route
+// cache in one batch and calls the weather microservice only for the misses, several at a time.
+// Fetched bodies are written back under the per-route keys, so the single-location endpoints reuse them too.
+func (g *Gateway) resolveWeatherLookups(ctx context.Context, command string, lookups *weatherLookups) ([][]byte, []error) {
+	bodies := make([][]byte, len(lookups.keys))
+	errs := make([]error, len(lookups.keys))
+	g.streamWeatherLookups(ctx, command, lookups, func(i int, body []byte, err error) {
+		bodies[i], errs[i] = body, err
+	})
+	return bodies, errs
+}
+
+// streamWeatherLookups resolves lookups like resolveWeatherLookups, but calls resolved for each lookup as soon
+// as its body is known: the cached ones first, then the fetched ones in the order they complete.
+// resolved runs on the calling goroutine.
+func (g *Gateway) streamWeatherLookups(ctx context.Context, command string, lookups *weatherLookups, resolved func(i int, body []byte, err error)) {
+	var misses []int
+	cached, err := g.cache.MGet(ctx, lookups.keys...)
+	for i := range lookups.keys {
+		if err == nil && cached[i] != "" {
+			resolved(i, []byte(cached[i]), nil)
+			continue
+		}
+		misses = append(misses, i)
+	}
+
+	ttl := lookups.ttl
+	if ttl == 0 {
+		ttl = time.Hour
+	}
+	fanOutEach(ctx, len(misses), g.aggregationWorkers(), func(ctx context.Context, m int) weatherFetch {
+		i := misses[m]
+		body, err := fetchWeather(ctx, command, RoundRobinBalancer()+lookups.paths[i])
+		if err == nil {
+			g.cache.Set(context.Background(), lookups.keys[i], string(body), ttl)
+			if lookups.fetched != nil {
+				lookups.fetched(i, body)
+			}
+		}
+		return weatherFetch{body: body, err: err}
+	}, func(m int, result weatherFetch) {
+		resolved(misses[m], result.body, result.err)
+	})
+}
+
+// ItemError describes why a single item of an aggregation could not be resolved
+type ItemError struct {
+	Message string `json:"message"`
+	Cause   string `json:"cause"`
+}
+
+// newItemError returns nil when err is nil, so it can be assigned directly to an optional error field
+func newItemError(message string, err error) *ItemError {
+	if err == nil {
+		return nil
+	}
+	return &ItemError{Message: message, Cause: err.Error()}
+}
+
+// writeAggregation encodes an aggregation response and returns the body so it can be cached.
+// Responses with missing items are sent as 207 Multi-Status, so clients can still use the resolved ones.
+func writeAggregation(w http.ResponseWriter, response interface{}, partial bool) ([]byte, error) {
+	body, err := json.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+
+	status := http.StatusOK
+	if partial {
+		status = http.StatusMultiStatus
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+	return body, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFanOut(t *testing.T) {
+	tests := []struct {
+		name  string
+		n     int
+		limit int
+	}{
+		{"more items than workers", 12, 3},
+		{"more workers than items", 3, 10},
+		{"limit below one", 4, 0},
+		{"no items", 0, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var running, peak int32
+			results := fanOut(context.Background(), tt.n, tt.limit, func(ctx context.Context, i int) int {
+				now := atomic.AddInt32(&running, 1)
+				defer atomic.AddInt32(&running, -1)
+				for {
+					seen := atomic.LoadInt32(&peak)
+					if now <= seen || atomic.CompareAndSwapInt32(&peak, seen, now) {
+						break
+					}
+				}
+				// Later items finish first, so the results arrive out of order
+				time.Sleep(time.Duration(tt.n-i) * time.Millisecond)
+				return i * i
+			})
+
+			want := make([]int, tt.n)
+			for i := range want {
+				want[i] = i * i
+			}
+			if !reflect.DeepEqual(results, want) {
+				t.Errorf("fanOut = %v, want %v", results, want)
+			}
+			if limit := int32(max(tt.limit, 1)); peak > limit {
+				t.Errorf("%d items ran at the same time, want at most %d", peak, limit)
+			}
+		})
+	}
+}
+
+func TestAcquireWeatherSlot(t *testing.T) {
+	ctx := context.Background()
+	var releases []func()
+	for i := 0; i < weatherCommandMaxConcurrency; i++ {
+		release, err := acquireWeatherSlot(ctx, "testSlots")
+		if err != nil {
+			t.Fatalf("slot %d: %v", i, err)
+		}
+		releases = append(releases, release)
+	}
+
+	// Every slot is taken, so the next caller waits until its context ends
+	waiting, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := acquireWeatherSlot(waiting, "testSlots"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("acquire with every slot taken: %v, want context.DeadlineExceeded", err)
+	}
+	if release, err := acquireWeatherSlot(ctx, "otherCommand"); err != nil {
+		t.Fatalf("another command's slot: %v", err)
+	} else {
+		release()
+	}
+
+	releases[0]()
+	release, err := acquireWeatherSlot(ctx, "testSlots")
+	if err != nil {
+		t.Fatalf("acquire after a release: %v", err)
+	}
+	release()
+	for _, release := range releases[1:] {
+		release()
+	}
+}
+
+func TestFanOutCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	errs := fanOut(ctx, 5, 2, func(ctx context.Context, i int) error {
+		return ctx.Err()
+	})
+	for i, err := range errs {
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("item %d error = %v, want context.Canceled", i, err)
+		}
+	}
+}
+
+func TestResolveWeatherLookups(t *testing.T) {
+	var calls int32
+	weather := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.URL.Query().Get("location") == "Broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"location":"` + r.URL.Query().Get("location") + `"}`))
+	}))
+	defer weather.Close()
+	hostnames := weatherHostnames
+	weatherHostnames = []string{weather.URL}
+	t.Cleanup(func() { weatherHostnames = hostnames })
+
+	ctx := context.Background()
+	g := newTestGateway(t, "2023-10-09T12:00:00Z")
+	g.cache.Set(ctx, "Cached_09.10.2023", `{"location":"Cached"}`, time.Hour)
+
+	var lookups weatherLookups
+	for _, location := range []string{"Boston", "Cached", "Broken", "Boston"} {
+		lookups.add(location+"_09.10.2023", "/weather_forecast?location="+location+"&date=09.10.2023")
+	}
+	var fetched []int
+	lookups.fetched = func(i int, body []byte) { fetched = append(fetched, i) }
+
+	bodies, errs := g.resolveWeatherLookups(ctx, "getWeather", &lookups)
+
+	tests := []struct {
+		location string
+		wantBody string
+		wantErr  bool
+	}{
+		{"Boston", `{"location":"Boston"}`, false},
+		{"Cached", `{"location":"Cached"}`, false},
+		{"Broken", "", true},
+	}
+	if len(bodies) != len(tests) {
+		t.Fatalf("%d lookups, want %d; repeated lookups must be shared", len(bodies), len(tests))
+	}
+	for i, tt := range tests {
+		if string(bodies[i]) != tt.wantBody || (errs[i] != nil) != tt.wantErr {
+			t.Errorf("%s: body %q, error %v; want %q, error %v", tt.location, bodies[i], errs[i], tt.wantBody, tt.wantErr)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("%d weather microservice calls, want 2 (the cached lookup is not fetched)", calls)
+	}
+	if !reflect.DeepEqual(fetched, []int{0}) {
+		t.Errorf("fetched called for lookups %v, want [0]", fetched)
+	}
+	if cached, err := g.cache.Get(ctx, "Boston_09.10.2023"); err != nil || cached != tests[0].wantBody {
+		t.Errorf("fetched body cached as %q, %v", cached, err)
+	}
+	if _, err := g.cache.Get(ctx, "Broken_09.10.2023"); err != errCacheMiss {
+		t.Errorf("failed lookup cached, error %v", err)
+	}
+}
+
+func TestWriteAggregation(t *testing.T) {
+	tests := []struct {
+		partial    bool
+		wantStatus int
+	}{
+		{false, http.StatusOK},
+		{true, http.StatusMultiStatus},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		body, err := writeAggregation(w, map[string]bool{"partial": tt.partial}, tt.partial)
+		if err != nil || w.Code != tt.wantStatus || w.Body.String() != string(body) {
+			t.Errorf("partial %v: status %d, error %v; want %d", tt.partial, w.Code, err, tt.wantStatus)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// alertSeverities orders the severity levels from least to most severe
+var alertSeverities = map[string]int{"info": 0, "warning": 1, "severe": 2}
+
+// AlertRule fires for a match when one hour of its faceoff window meets every threshold the rule sets.
+// Thresholds are inclusive; Conditions match parts of the forecast condition, ignoring case.
+type AlertRule struct {
+	Name              string   `json:"name"`
+	Severity          string   `json:"severity"` // "info", "warning" or "severe"
+	Conditions        []string `json:"conditions,omitempty"`
+	ChanceOfRainAbove *int     `json:"chance_of_rain_above,omitempty"`
+	WindMPHAbove      *float64 `json:"wind_mph_above,omitempty"`
+	TempCAbove        *float64 `json:"temp_c_above,omitempty"`
+	TempCBelow        *float64 `json:"temp_c_below,omitempty"`
+}
+
+// defaultAlertRules are used when ALERT_RULES is not set
+var defaultAlertRules = []AlertRule{
+	{Name: "heavy snow", Severity: "severe", Conditions: []string{"heavy snow", "blizzard"}},
+	{Name: "freezing rain", Severity: "severe", Conditions: []string{"freezing rain", "ice pellets"}},
+	{Name: "strong wind", Severity: "warning", WindMPHAbove: floatPtr(30)},
+	{Name: "heavy rain", Severity: "warning", ChanceOfRainAbove: intPtr(80)},
+	{Name: "extreme cold", Severity: "warning", TempCBelow: floatPtr(-20)},
+	{Name: "snow", Severity: "info", Conditions: []string{"snow"}},
+}
+
+func intPtr(value int) *int {
+	return &value
+}
+
+func floatPtr(value float64) *float64 {
+	return &value
+}
+
+// matches reports whether an hour of weather meets all the rule's thresholds. The thresholds are strict:
+// a value equal to an *Above or *Below threshold doesn't fire the rule.
+func (rule AlertRule) matches(weather HourlyWeather) bool {
+	if len(rule.Conditions) > 0 {
+		condition := strings.ToLower(weather.Condition)
+		found := false
+		for _, wanted := range rule.Conditions {
+			if strings.Contains(condition, strings.ToLower(wanted)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if rule.ChanceOfRainAbove != nil && weather.ChanceOfRain <= *rule.ChanceOfRainAbove {
+		return false
+	}
+	if rule.WindMPHAbove != nil && weather.WindMPH <= *rule.WindMPHAbove {
+		return false
+	}
+	if rule.TempCAbove != nil && weather.TempC <= *rule.TempCAbove {
+		return false
+	}
+	if rule.TempCBelow != nil && weather.TempC >= *rule.TempCBelow {
+		return false
+	}
+	return true
+}
+
+// loadAlertRules parses the ALERT_RULES JSON array, falling back to the default rules
+func loadAlertRules(raw string) []AlertRule {
+	if raw == "" {
+		return defaultAlertRules
+	}
+
+	var rules []AlertRule
+	if err := json.Unmarshal([]byte(raw), &rules); err != nil {
+		fmt.Println("Error parsing ALERT_RULES, using the default rules:", err)
+		return defaultAlertRules
+	}
+	for i, rule := range rules {
+		if _, ok := alertSeverities[rule.Severity]; !ok {
+			fmt.Printf("Unknown severity %q in alert rule %q, using warning\n", rule.Severity, rule.Name)
+			rules[i].Severity = "warning"
+		}
+	}
+	return rules
+}
+
+// WeatherAlert is a rule that fired for an upcoming match
+type WeatherAlert struct {
+	UID            string        `json:"uid"`
+	Match          string        `json:"match"`
+	City           string        `json:"city"`
+	Rule           string        `json:"rule"`
+	Severity       string        `json:"severity"`
+	Faceoff        string        `json:"faceoff"`
+	Hour           string        `json:"hour"` // First hour of the faceoff window the rule fired for
+	Message        string        `json:"message"`
+	WeatherAffects string        `json:"weather_affects,omitempty"`
+	Weather        HourlyWeather `json:"weather"`
+}
+
+// WeatherAlertsResponse lists the alerts for the upcoming matches
+type WeatherAlertsResponse struct {
+	Partial bool           `json:"partial"`
+	Alerts  []WeatherAlert `json:"alerts"`
+	Errors  []ItemError    `json:"errors,omitempty"`
+}
+
+// scanAlerts evaluates the alert rules against the faceoff window of every upcoming match
+func (g *Gateway) scanAlerts(ctx context.Context, today string) (WeatherAlertsResponse, error) {
+	matches, err := g.upcomingMatches(ctx, today)
+	if err != nil {
+		return WeatherAlertsResponse{}, err
+	}
+
+	var forecastMatches []Match
+	for _, match := range matches {
+		if match.City != "" {
+			forecastMatches = append(forecastMatches, match)
+		}
+	}
+
+	response := WeatherAlertsResponse{Alerts: []WeatherAlert{}}
+	for _, forecast := range g.matchForecasts(ctx, forecastMatches, false) {
+		if forecast.Error != nil {
+			response.Partial = true
+			response.Errors = append(response.Errors, *forecast.Error)
+			continue
+		}
+		// Climatological averages are no basis for an alert
+		if forecast.AtFaceoff == nil || forecast.Source == sourceClimatology {
+			continue
+		}
+		response.Alerts = append(response.Alerts, g.matchAlerts(forecast)...)
+	}
+	return response, nil
+}
+
+// matchAlerts returns the alerts that fire for one match, at most one per rule
+func (g *Gateway) matchAlerts(forecast WeatherForecastResponseWithInfo) []WeatherAlert {
+	faceoffAt, err := time.Parse(time.RFC3339, forecast.AtFaceoff.Faceoff)
+	if err != nil {
+		return nil
+	}
+
+	var alerts []WeatherAlert
+	hours := sortedHours(forecast.Forecast.HourlyWeather)
+	for _, rule := range g.config.AlertRules {
+		for _, hour := range hours {
+			weather := forecast.Forecast.HourlyWeather[hour]
+			if !rule.matches(weather) {
+				continue
+			}
+
+			alert := WeatherAlert{
+				UID:      forecast.UID,
+				City:     forecast.City,
+				Rule:     rule.Name,
+				Severity: rule.Severity,
+				Faceoff:  forecast.AtFaceoff.Faceoff,
+				Hour:     hour,
+				Message:  fmt.Sprintf("%s expected in %s %s", rule.Name, forecast.City, relativeToFaceoff(faceoffAt, hour)),
+				Weather:  weather,
+			}
+			if forecast.Match != nil {
+				alert.Match = forecast.Match.Name
+			}
+			if forecast.Venue != nil {
+				alert.WeatherAffects = forecast.Venue.WeatherAffects
+			}
+			alerts = append(alerts, alert)
+			break
+		}
+	}
+	return alerts
+}
+
+// relativeToFaceoff describes an "HH:MM" hour of the faceoff day relative to faceoff, e.g. "3 h before faceoff"
+func relativeToFaceoff(faceoffAt time.Time, hour string) string {
+	clock, err := time.Parse("15:04", hour)
+	if err != nil {
+		return "around faceoff"
+	}
+	at := time.Date(faceoffAt.Year(), faceoffAt.Month(), faceoffAt.Day(), clock.Hour(), clock.Minute(), 0, 0, faceoffAt.Location())
+
+	hours := int(faceoffAt.Truncate(time.Hour).Sub(at).Hours())
+	switch {
+	case hours > 0:
+		return fmt.Sprintf("%d h before faceoff", hours)
+	case hours < 0:
+		return fmt.Sprintf("%d h after faceoff", -hours)
+	default:
+		return "at faceoff"
+	}
+}
+
+// sortedHours returns the "HH:MM" keys of an hourly forecast in chronological order
+func sortedHours(hourly map[string]HourlyWeather) []string {
+	hours := make([]string, 0, len(hourly))
+	for hour := range hourly {
+		hours = append(hours, hour)
+	}
+	sort.Strings(hours)
+	return hours
+}
+
+// filterAlerts keeps the alerts at or above a severity level
+func filterAlerts(alerts []WeatherAlert, minSeverity string) []WeatherAlert {
+	filtered := []WeatherAlert{}
+	for _, alert := range alerts {
+		if alertSeverities[alert.Severity] >= alertSeverities[minSeverity] {
+			filtered = append(filtered, alert)
+		}
+	}
+	return filtered
+}
+
+// getWeatherAlerts returns the weather alerts for upcoming matches, optionally only from a severity level up
+func (g *Gateway) getWeatherAlerts(w http.ResponseWriter, r *http.Request) {
+	minSeverity := r.URL.Query().Get("severity")
+	if minSeverity == "" {
+		minSeverity = "info"
+	}
+	if _, ok := alertSeverities[minSeverity]; !ok {
+		http.Error(w, "Severity must be info, warning or severe", http.StatusBadRequest)
+		return
+	}
+
+	today, ok := g.requestToday(w, r)
+	if !ok {
+		return
+	}
+
+	// Create a cache key based on today's date
+	cacheKey := "weather_alerts_" + today
+
+	var response WeatherAlertsResponse
+	cachedResult, err := g.cache.Get(r.Context(), cacheKey)
+	if err == nil {
+		err = json.Unmarshal([]byte(cachedResult), &response)
+	}
+	if err != nil {
+		response, err = g.scanAlerts(r.Context(), today)
+		if err != nil {
+			http.Error(w, "Error making request to matches_ms for upcoming matches", http.StatusInternalServerError)
+			return
+		}
+		g.cacheAlerts(cacheKey, response)
+	}
+
+	// Severity is filtered after the cache, so every level shares the same entry
+	response.Alerts = filterAlerts(response.Alerts, minSeverity)
+	if _, err := writeAggregation(w, response, response.Partial); err != nil {
+		http.Error(w, "Error encoding weather alerts response", http.StatusInternalServerError)
+	}
+}
+
+// cacheAlerts caches a complete alert scan for an hour
+func (g *Gateway) cacheAlerts(cacheKey string, response WeatherAlertsResponse) {
+	if response.Partial {
+		return
+	}
+	if body, err := json.Marshal(response); err == nil {
+		g.cache.Set(context.Background(), cacheKey, string(body), time.Hour)
+	}
+}
+
+// startAlertScanner schedules the alert scan according to the configured cron expression.
+// Each run refreshes the cached alerts and logs the new ones.
+func (g *Gateway) startAlertScanner() {
+	if g.config.AlertSchedule == "" {
+		return
+	}
+
+	scheduler := cron.New()
+	_, err := scheduler.AddFunc(g.config.AlertSchedule, func() {
+		ctx := context.Background()
+		location, err := time.LoadLocation(g.config.DefaultTimezone)
+		if err != nil {
+			fmt.Println("Error loading the default timezone for the alert scan:", err)
+			return
+		}
+		today := g.now(ctx).In(location).Format(dateKeyLayout)
+
+		response, err := g.scanAlerts(ctx, today)
+		if err != nil {
+			fmt.Println("Error scanning weather alerts:", err)
+			return
+		}
+		g.cacheAlerts("weather_alerts_"+today, response)
+
+		for _, alert := range response.Alerts {
+			fmt.Printf("Weather alert [%s] %s: %s\n", alert.Severity, alert.Match, alert.Message)
+		}
+	})
+	if err != nil {
+		fmt.Println("Error scheduling the alert scan:", err)
+		return
+	}
+
+	scheduler.Start()
+	fmt.Println("Weather alert scan scheduled:", g.config.AlertSchedule)
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestAlertRuleMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    AlertRule
+		weather HourlyWeather
+		want    bool
+	}{
+		{"wind above", AlertRule{WindMPHAbove: floatPtr(30)}, HourlyWeather{WindMPH: 30.1}, true},
+		{"wind at the threshold", AlertRule{WindMPHAbove: floatPtr(30)}, HourlyWeather{WindMPH: 30}, false},
+		{"rain above", AlertRule{ChanceOfRainAbove: intPtr(80)}, HourlyWeather{ChanceOfRain: 81}, true},
+		{"rain at the threshold", AlertRule{ChanceOfRainAbove: intPtr(80)}, HourlyWeather{ChanceOfRain: 80}, false},
+		{"heat above", AlertRule{TempCAbove: floatPtr(30)}, HourlyWeather{TempC: 30.5}, true},
+		{"heat at the threshold", AlertRule{TempCAbove: floatPtr(30)}, HourlyWeather{TempC: 30}, false},
+		{"cold below", AlertRule{TempCBelow: floatPtr(-20)}, HourlyWeather{TempC: -20.5}, true},
+		{"cold at the threshold", AlertRule{TempCBelow: floatPtr(-20)}, HourlyWeather{TempC: -20}, false},
+		{"condition", AlertRule{Conditions: []string{"heavy snow"}}, HourlyWeather{Condition: "Heavy snow showers"}, true},
+		{"condition and threshold", AlertRule{Conditions: []string{"snow"}, WindMPHAbove: floatPtr(30)}, HourlyWeather{Condition: "Snow", WindMPH: 20}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.matches(tt.weather); got != tt.want {
+			t.Errorf("%s: matches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
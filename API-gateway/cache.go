@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// errCacheMiss is returned by Cache.Get when the key is not cached
+var errCacheMiss = errors.New("cache miss")
+
+// Cache stores upstream responses for the handlers
+type Cache interface {
+	// Get returns the cached value, or errCacheMiss if there is none
+	Get(ctx context.Context, key string) (string, error)
+	// MGet returns the values of several keys in one round trip; keys that are not cached yield ""
+	MGet(ctx context.Context, keys ...string) ([]string, error)
+	// Set stores a value that expires after ttl (0 means it never expires)
+	Set(ctx context.Context, key string, value string, ttl time.Duration) error
+	// Delete removes the given keys, ignoring the ones that are not cached
+	Delete(ctx context.Context, keys ...string) error
+	// TTL returns the remaining time to live of a key, or errCacheMiss if it is not cached
+	TTL(ctx context.Context, key string) (time.Duration, error)
+}
+
+// Locker is implemented by caches that can atomically set a key only when it is absent
+type Locker interface {
+	SetNX(ctx context.Context, key string, value string, ttl time.Duration) (bool, error)
+	// Renew resets the ttl of a key only while it still holds value, and reports whether it did
+	Renew(ctx context.Context, key string, value string, ttl time.Duration) (bool, error)
+	// Release deletes a key only while it still holds value, so a lock that expired and was taken
+	// by someone else is left alone
+	Release(ctx context.Context, key string, value string) error
+}
+
+// Queue is implemented by caches that can hold lists shared by every gateway, used as work queues
+type Queue interface {
+	// Push appends a value to the end of a list
+	Push(ctx context.Context, key string, value string) error
+	// Pop removes and returns the first value of a list, or errCacheMiss if it is empty
+	Pop(ctx context.Context, key string) (string, error)
+	// Move atomically takes the first value of one list and appends it to another, so it is never in
+	// neither list; it returns errCacheMiss if the source list is empty
+	Move(ctx context.Context, source string, destination string) (string, error)
+	// Remove deletes a value from a list and reports whether it was there
+	Remove(ctx context.Context, key string, value string) (bool, error)
+	// Range returns all the values of a list
+	Range(ctx context.Context, key string) ([]string, error)
+}
+
+// cacheQueue returns the cache's queue, or nil when the cache backend can't hold one
+func (g *Gateway) cacheQueue() Queue {
+	queue, _ := g.cache.(Queue)
+	return queue
+}
+
+// newCache creates the cache backend selected in the configuration
+func newCache(cfg Config, clock Clock) (Cache, error) {
+	var backend Cache
+	switch cfg.CacheBackend {
+	case "redis":
+		backend = newRedisCache(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB)
+	case "memory":
+		backend = newMemoryCache(clock)
+	case "none":
+		return noCache{}, nil
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", cfg.CacheBackend)
+	}
+
+	return &instrumentedCache{
+		backend:              backend,
+		compression:          cfg.CacheCompression,
+		compressionThreshold: cfg.CacheCompressionThreshold,
+	}, nil
+}
+
+// noCache is used when caching is disabled: every lookup misses and writes are dropped
+type noCache struct{}
+
+func (noCache) Get(ctx context.Context, key string) (string, error) {
+	cacheRequests.WithLabelValues("miss").Inc()
+	return "", errCacheMiss
+}
+
+func (noCache) MGet(ctx context.Context, keys ...string) ([]string, error) {
+	cacheRequests.WithLabelValues("miss").Add(float64(len(keys)))
+	return make([]string, len(keys)), nil
+}
+
+func (noCache) Set(ctx context.Context, key string, value string, ttl time.Duration) error {
+	return nil
+}
+
+func (noCache) Delete(ctx context.Context, keys ...string) error {
+	return nil
+}
+
+func (noCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return 0, errCacheMiss
+}
+
+// SetNX always succeeds, since without a shared cache there is nobody to compete with
+func (noCache) SetNX(ctx context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	return true, nil
+}
+
+func (noCache) Renew(ctx context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	return true, nil
+}
+
+func (noCache) Release(ctx context.Context, key string, value string) error {
+	return nil
+}
+
+// Cache values larger than the configured threshold are compressed and prefixed with a format byte.
+// Plain JSON never starts with these bytes, so entries written before compression existed still read correctly.
+const (
+	cacheFormatGzip byte = 0x01
+)
+
+// cacheRefreshKey marks a context whose cache lookups must miss, so handlers refetch and rewrite the entry
+type cacheRefreshKey struct{}
+
+// cacheRefresh lists the keys a context refreshes; a nil set refreshes every key
+type cacheRefresh struct {
+	keys map[string]bool
+}
+
+// withCacheRefresh returns a context that makes lookups of the given keys skip the cache,
+// or of every key when none are given
+func withCacheRefresh(ctx context.Context, keys ...string) context.Context {
+	refresh := cacheRefresh{}
+	if len(keys) > 0 {
+		refresh.keys = make(map[string]bool, len(keys))
+		for _, key := range keys {
+			refresh.keys[key] = true
+		}
+	}
+	return context.WithValue(ctx, cacheRefreshKey{}, refresh)
+}
+
+// refreshesKey reports whether a lookup of key must skip the cache
+func refreshesKey(ctx context.Context, key string) bool {
+	refresh, ok := ctx.Value(cacheRefreshKey{}).(cacheRefresh)
+	return ok && (refresh.keys == nil || refresh.keys[key])
+}
+
+// instrumentedCache wraps a backend with transparent compression, hit/miss metrics and refresh support
+type instrumentedCache struct {
+	backend              Cache
+	compression          string
+	compressionThreshold int
+}
+
+// Get reads a value from the backend and transparently decompresses it
+func (c *instrumentedCache) Get(ctx context.Context, key string) (string, error) {
+	if refreshesKey(ctx, key) {
+		return "", errCacheMiss
+	}
+
+	stored, err := c.backend.Get(ctx, key)
+	if err != nil {
+		cacheRequests.WithLabelValues("miss").Inc()
+		return "", err
+	}
+
+	value, err := decodeCacheValue(stored)
+	if err != nil {
+		// An unreadable entry is treated like a miss so the caller refetches it
+		fmt.Printf("Error decoding cache entry %s: %v\n", key, err)
+		cacheRequests.WithLabelValues("miss").Inc()
+		return "", err
+	}
+
+	cacheRequests.WithLabelValues("hit").Inc()
+	cacheReadBytes.WithLabelValues("stored").Add(float64(len(stored)))
+	cacheReadBytes.WithLabelValues("raw").Add(float64(len(value)))
+	return value, nil
+}
+
+// MGet reads several values from the backend at once; entries that cannot be decoded are reported as misses
+func (c *instrumentedCache) MGet(ctx context.Context, keys ...string) ([]string, error) {
+	values := make([]string, len(keys))
+	if len(keys) == 0 {
+		return values, nil
+	}
+
+	stored, err := c.backend.MGet(ctx, keys...)
+	if err != nil {
+		cacheRequests.WithLabelValues("miss").Add(float64(len(keys)))
+		return nil, err
+	}
+
+	for i, entry := range stored {
+		if entry == "" || refreshesKey(ctx, keys[i]) {
+			cacheRequests.WithLabelValues("miss").Inc()
+			continue
+		}
+
+		value, err := decodeCacheValue(entry)
+		if err != nil {
+			fmt.Printf("Error decoding cache entry %s: %v\n", keys[i], err)
+			cacheRequests.WithLabelValues("miss").Inc()
+			continue
+		}
+
+		cacheRequests.WithLabelValues("hit").Inc()
+		cacheReadBytes.WithLabelValues("stored").Add(float64(len(entry)))
+		cacheReadBytes.WithLabelValues("raw").Add(float64(len(value)))
+		values[i] = value
+	}
+	return values, nil
+}
+
+// Set compresses a value when it is large enough and writes it to the backend
+func (c *instrumentedCache) Set(ctx context.Context, key string, value string, ttl time.Duration) error {
+	stored := c.encodeCacheValue(value)
+
+	cacheWrittenBytes.WithLabelValues("raw").Add(float64(len(value)))
+	cacheWrittenBytes.WithLabelValues("stored").Add(float64(len(stored)))
+	return c.backend.Set(ctx, key, stored, ttl)
+}
+
+func (c *instrumentedCache) Delete(ctx context.Context, keys ...string) error {
+	return c.backend.Delete(ctx, keys...)
+}
+
+func (c *instrumentedCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return c.backend.TTL(ctx, key)
+}
+
+// SetNX is forwarded to the backend; locks are stored uncompressed
+func (c *instrumentedCache) SetNX(ctx context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	locker, ok := c.backend.(Locker)
+	if !ok {
+		return false, fmt.Errorf("cache backend does not support locking")
+	}
+	return locker.SetNX(ctx, key, value, ttl)
+}
+
+func (c *instrumentedCache) Renew(ctx context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	locker, ok := c.backend.(Locker)
+	if !ok {
+		return false, fmt.Errorf("cache backend does not support locking")
+	}
+	return locker.Renew(ctx, key, value, ttl)
+}
+
+func (c *instrumentedCache) Release(ctx context.Context, key string, value string) error {
+	locker, ok := c.backend.(Locker)
+	if !ok {
+		return fmt.Errorf("cache backend does not support locking")
+	}
+	return locker.Release(ctx, key, value)
+}
+
+// queue returns the backend's Queue implementation
+func (c *instrumentedCache) queue() (Queue, error) {
+	queue, ok := c.backend.(Queue)
+	if !ok {
+		return nil, fmt.Errorf("cache backend does not support queues")
+	}
+	return queue, nil
+}
+
+// Push is forwarded to the backend; list values are stored uncompressed
+func (c *instrumentedCache) Push(ctx context.Context, key string, value string) error {
+	queue, err := c.queue()
+	if err != nil {
+		return err
+	}
+	return queue.Push(ctx, key, value)
+}
+
+func (c *instrumentedCache) Pop(ctx context.Context, key string) (string, error) {
+	queue, err := c.queue()
+	if err != nil {
+		return "", err
+	}
+	return queue.Pop(ctx, key)
+}
+
+func (c *instrumentedCache) Move(ctx context.Context, source string, destination string) (string, error) {
+	queue, err := c.queue()
+	if err != nil {
+		return "", err
+	}
+	return queue.Move(ctx, source, destination)
+}
+
+func (c *instrumentedCache) Remove(ctx context.Context, key string, value string) (bool, error) {
+	queue, err := c.queue()
+	if err != nil {
+		return false, err
+	}
+	return queue.Remove(ctx, key, value)
+}
+
+func (c *instrumentedCache) Range(ctx context.Context, key string) ([]string, error) {
+	queue, err := c.queue()
+	if err != nil {
+		return nil, err
+	}
+	return queue.Range(ctx, key)
+}
+
+// encodeCacheValue returns the representation of a value as it is stored in the cache
+func (c *instrumentedCache) encodeCacheValue(value string) string {
+	if c.compression != "gzip" || len(value) < c.compressionThreshold {
+		return value
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(cacheFormatGzip)
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(value)); err != nil {
+		return value
+	}
+	if err := zw.Close(); err != nil {
+		return value
+	}
+
+	// Keep the raw value if compression did not save anything
+	if buf.Len() >= len(value) {
+		return value
+	}
+	return buf.String()
+}
+
+// decodeCacheValue turns a stored cache value back into the original payload
+func decodeCacheValue(stored string) (string, error) {
+	if len(stored) == 0 || stored[0] != cacheFormatGzip {
+		return stored, nil
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader([]byte(stored[1:])))
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+
+	value, err := io.ReadAll(zr)
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memoryCache keeps cache entries in the gateway process, for tests and single-instance setups
+type memoryCache struct {
+	mu      sync.Mutex
+	clock   Clock
+	entries map[string]memoryCacheEntry
+	lists   map[string][]string
+}
+
+type memoryCacheEntry struct {
+	value     string
+	expiresAt time.Time // Zero when the entry never expires
+}
+
+func newMemoryCache(clock Clock) *memoryCache {
+	return &memoryCache{clock: clock, entries: make(map[string]memoryCacheEntry), lists: make(map[string][]string)}
+}
+
+// lookup returns a live entry, dropping it if it has expired. The caller must hold the lock.
+func (c *memoryCache) lookup(key string) (memoryCacheEntry, bool) {
+	entry, ok := c.entries[key]
+	if !ok {
+		return entry, false
+	}
+	if !entry.expiresAt.IsZero() && !c.clock.Now().Before(entry.expiresAt) {
+		delete(c.entries, key)
+		return entry, false
+	}
+	return entry, true
+}
+
+func (c *memoryCache) Get(ctx context.Context, key string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.lookup(key)
+	if !ok {
+		return "", errCacheMiss
+	}
+	return entry.value, nil
+}
+
+func (c *memoryCache) MGet(ctx context.Context, keys ...string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		if entry, ok := c.lookup(key); ok {
+			values[i] = entry.value
+		}
+	}
+	return values, nil
+}
+
+func (c *memoryCache) Set(ctx context.Context, key string, value string, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := memoryCacheEntry{value: value}
+	if ttl > 0 {
+		entry.expiresAt = c.clock.Now().Add(ttl)
+	}
+	c.entries[key] = entry
+	return nil
+}
+
+func (c *memoryCache) Delete(ctx context.Context, keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		delete(c.entries, key)
+	}
+	return nil
+}
+
+func (c *memoryCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.lookup(key)
+	if !ok {
+		return 0, errCacheMiss
+	}
+	if entry.expiresAt.IsZero() {
+		return 0, nil
+	}
+	return entry.expiresAt.Sub(c.clock.Now()), nil
+}
+
+func (c *memoryCache) SetNX(ctx context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.lookup(key); ok {
+		return false, nil
+	}
+
+	entry := memoryCacheEntry{value: value}
+	if ttl > 0 {
+		entry.expiresAt = c.clock.Now().Add(ttl)
+	}
+	c.entries[key] = entry
+	return true, nil
+}
+
+func (c *memoryCache) Renew(ctx context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.lookup(key)
+	if !ok || entry.value != value {
+		return false, nil
+	}
+	entry.expiresAt = time.Time{}
+	if ttl > 0 {
+		entry.expiresAt = c.clock.Now().Add(ttl)
+	}
+	c.entries[key] = entry
+	return true, nil
+}
+
+func (c *memoryCache) Release(ctx context.Context, key string, value string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.lookup(key); ok && entry.value == value {
+		delete(c.entries, key)
+	}
+	return nil
+}
+
+func (c *memoryCache) Push(ctx context.Context, key string, value string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lists[key] = append(c.lists[key], value)
+	return nil
+}
+
+func (c *memoryCache) Pop(ctx context.Context, key string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	list := c.lists[key]
+	if len(list) == 0 {
+		return "", errCacheMiss
+	}
+	c.lists[key] = list[1:]
+	return list[0], nil
+}
+
+func (c *memoryCache) Move(ctx context.Context, source string, destination string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	list := c.lists[source]
+	if len(list) == 0 {
+		return "", errCacheMiss
+	}
+	c.lists[source] = list[1:]
+	c.lists[destination] = append(c.lists[destination], list[0])
+	return list[0], nil
+}
+
+func (c *memoryCache) Remove(ctx context.Context, key string, value string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	list := c.lists[key]
+	kept := make([]string, 0, len(list))
+	for _, item := range list {
+		if item != value {
+			kept = append(kept, item)
+		}
+	}
+	c.lists[key] = kept
+	return len(kept) < len(list), nil
+}
+
+func (c *memoryCache) Range(ctx context.Context, key string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string(nil), c.lists[key]...), nil
+}
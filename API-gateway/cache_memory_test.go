@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestMemoryCache returns a memory cache with its clock pinned to now, and a function that moves the clock
+func newTestMemoryCache(t *testing.T, now string) (*memoryCache, func(time.Duration)) {
+	t.Helper()
+	pinned, err := parseClockTime(now)
+	if err != nil {
+		t.Fatalf("parseClockTime(%q): %v", now, err)
+	}
+	cache := newMemoryCache(offsetClock{offset: time.Until(pinned)})
+	advance := func(d time.Duration) {
+		cache.clock = offsetClock{offset: cache.clock.(offsetClock).offset + d}
+	}
+	return cache, advance
+}
+
+func TestMemoryCacheExpiry(t *testing.T) {
+	tests := []struct {
+		name    string
+		ttl     time.Duration
+		elapsed time.Duration
+		wantHit bool
+		wantTTL time.Duration
+	}{
+		{"no expiry", 0, 1000 * time.Hour, true, 0},
+		{"before expiry", time.Hour, 30 * time.Minute, true, 30 * time.Minute},
+		{"at expiry", time.Hour, time.Hour, false, 0},
+		{"after expiry", time.Hour, 2 * time.Hour, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cache, advance := newTestMemoryCache(t, "2023-10-09T12:00:00Z")
+			if err := cache.Set(ctx, "key", "value", tt.ttl); err != nil {
+				t.Fatal(err)
+			}
+			advance(tt.elapsed)
+
+			value, err := cache.Get(ctx, "key")
+			if tt.wantHit && (err != nil || value != "value") {
+				t.Fatalf("Get = %q, %v; want a hit", value, err)
+			}
+			if !tt.wantHit && err != errCacheMiss {
+				t.Fatalf("Get = %q, %v; want errCacheMiss", value, err)
+			}
+
+			ttl, err := cache.TTL(ctx, "key")
+			if tt.wantHit {
+				// The clock keeps running between the calls, so allow for a little drift
+				if err != nil || tt.wantTTL-ttl > time.Second || ttl > tt.wantTTL {
+					t.Errorf("TTL = %v, %v; want %v", ttl, err, tt.wantTTL)
+				}
+			} else if err != errCacheMiss {
+				t.Errorf("TTL error = %v, want errCacheMiss", err)
+			}
+		})
+	}
+}
+
+func TestMemoryCacheMGetAndDelete(t *testing.T) {
+	ctx := context.Background()
+	cache, advance := newTestMemoryCache(t, "2023-10-09T12:00:00Z")
+	cache.Set(ctx, "a", "1", 0)
+	cache.Set(ctx, "b", "2", time.Minute)
+	cache.Set(ctx, "c", "3", 0)
+	advance(time.Hour)
+	cache.Delete(ctx, "c", "missing")
+
+	values, err := cache.MGet(ctx, "a", "b", "c", "d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1", "", "", ""}; !reflect.DeepEqual(values, want) {
+		t.Errorf("MGet = %q, want %q", values, want)
+	}
+}
+
+func TestMemoryCacheLocks(t *testing.T) {
+	ctx := context.Background()
+	cache, advance := newTestMemoryCache(t, "2023-10-09T12:00:00Z")
+
+	steps := []struct {
+		name string
+		run  func() (bool, error)
+		want bool
+	}{
+		{"first SetNX takes the lock", func() (bool, error) { return cache.SetNX(ctx, "lock", "a", time.Minute) }, true},
+		{"second SetNX fails", func() (bool, error) { return cache.SetNX(ctx, "lock", "b", time.Minute) }, false},
+		{"holder renews", func() (bool, error) { return cache.Renew(ctx, "lock", "a", time.Minute) }, true},
+		{"other owner can't renew", func() (bool, error) { return cache.Renew(ctx, "lock", "b", time.Minute) }, false},
+		{"other owner can't release", func() (bool, error) {
+			err := cache.Release(ctx, "lock", "b")
+			_, getErr := cache.Get(ctx, "lock")
+			return getErr == nil, err
+		}, true},
+		{"lock expires", func() (bool, error) {
+			advance(2 * time.Minute)
+			return cache.SetNX(ctx, "lock", "b", time.Minute)
+		}, true},
+		{"expired holder can't renew", func() (bool, error) { return cache.Renew(ctx, "lock", "a", time.Minute) }, false},
+		{"holder releases", func() (bool, error) {
+			err := cache.Release(ctx, "lock", "b")
+			_, getErr := cache.Get(ctx, "lock")
+			return getErr == errCacheMiss, err
+		}, true},
+	}
+	for _, step := range steps {
+		got, err := step.run()
+		if err != nil || got != step.want {
+			t.Fatalf("%s: got %v, %v; want %v", step.name, got, err, step.want)
+		}
+	}
+}
+
+func TestMemoryCacheQueue(t *testing.T) {
+	ctx := context.Background()
+	cache, _ := newTestMemoryCache(t, "2023-10-09T12:00:00Z")
+	for _, value := range []string{"1", "2", "3", "2"} {
+		cache.Push(ctx, "queue", value)
+	}
+
+	if value, err := cache.Move(ctx, "queue", "running"); err != nil || value != "1" {
+		t.Errorf("Move = %q, %v; want 1", value, err)
+	}
+	if removed, err := cache.Remove(ctx, "queue", "2"); err != nil || !removed {
+		t.Errorf("Remove = %v, %v; want true", removed, err)
+	}
+	if removed, _ := cache.Remove(ctx, "queue", "missing"); removed {
+		t.Error("Remove of a missing value = true, want false")
+	}
+
+	lists := []struct {
+		key  string
+		want []string
+	}{
+		{"queue", []string{"3"}},
+		{"running", []string{"1"}},
+		{"empty", []string{}},
+	}
+	for _, list := range lists {
+		if values, err := cache.Range(ctx, list.key); err != nil || len(values) != len(list.want) || (len(values) > 0 && !reflect.DeepEqual(values, list.want)) {
+			t.Errorf("Range(%s) = %q, %v; want %q", list.key, values, err, list.want)
+		}
+	}
+
+	if value, err := cache.Pop(ctx, "queue"); err != nil || value != "3" {
+		t.Errorf("Pop = %q, %v; want 3", value, err)
+	}
+	if _, err := cache.Pop(ctx, "queue"); err != errCacheMiss {
+		t.Errorf("Pop of an empty list error = %v, want errCacheMiss", err)
+	}
+	if _, err := cache.Move(ctx, "queue", "running"); err != errCacheMiss {
+		t.Errorf("Move from an empty list error = %v, want errCacheMiss", err)
+	}
+}
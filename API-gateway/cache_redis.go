@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+// redisCache stores cache entries in Redis, shared by all gateway replicas
+type redisCache struct {
+	client *redis.Client
+}
+
+func newRedisCache(addr, password string, db int) *redisCache {
+	return &redisCache{
+		client: redis.NewClient(&redis.Options{
+			Addr:     addr,
+			Password: password,
+			DB:       db,
+		}),
+	}
+}
+
+func (c *redisCache) Get(ctx context.Context, key string) (string, error) {
+	value, err := c.client.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return "", errCacheMiss
+	}
+	return value, err
+}
+
+func (c *redisCache) MGet(ctx context.Context, keys ...string) ([]string, error) {
+	results, err := c.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]string, len(keys))
+	for i, result := range results {
+		// Missing keys come back as nil
+		if value, ok := result.(string); ok {
+			values[i] = value
+		}
+	}
+	return values, nil
+}
+
+func (c *redisCache) Set(ctx context.Context, key string, value string, ttl time.Duration) error {
+	return c.client.Set(ctx, key, value, ttl).Err()
+}
+
+func (c *redisCache) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return c.client.Del(ctx, keys...).Err()
+}
+
+func (c *redisCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := c.client.TTL(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	// Redis reports -2 for a missing key and -1 for a key without expiration
+	if ttl == -2 {
+		return 0, errCacheMiss
+	}
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+func (c *redisCache) SetNX(ctx context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	return c.client.SetNX(ctx, key, value, ttl).Result()
+}
+
+// renewScript resets the ttl of a key only while it holds the expected value
+var renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+func (c *redisCache) Renew(ctx context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	renewed, err := renewScript.Run(ctx, c.client, []string{key}, value, ttl.Milliseconds()).Int()
+	return renewed == 1, err
+}
+
+// releaseScript deletes a key only while it holds the expected value
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+func (c *redisCache) Release(ctx context.Context, key string, value string) error {
+	return releaseScript.Run(ctx, c.client, []string{key}, value).Err()
+}
+
+func (c *redisCache) Push(ctx context.Context, key string, value string) error {
+	return c.client.RPush(ctx, key, value).Err()
+}
+
+func (c *redisCache) Pop(ctx context.Context, key string) (string, error) {
+	value, err := c.client.LPop(ctx, key).Result()
+	if err == redis.Nil {
+		return "", errCacheMiss
+	}
+	return value, err
+}
+
+func (c *redisCache) Move(ctx context.Context, source string, destination string) (string, error) {
+	value, err := c.client.LMove(ctx, source, destination, "LEFT", "RIGHT").Result()
+	if err == redis.Nil {
+		return "", errCacheMiss
+	}
+	return value, err
+}
+
+func (c *redisCache) Remove(ctx context.Context, key string, value string) (bool, error) {
+	removed, err := c.client.LRem(ctx, key, 0, value).Result()
+	return removed > 0, err
+}
+
+func (c *redisCache) Range(ctx context.Context, key string) ([]string, error) {
+	return c.client.LRange(ctx, key, 0, -1).Result()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestCacheCompression(t *testing.T) {
+	large := `{"hourly_weather":` + strings.Repeat(`{"temp_c":12.5,"condition":"Partly cloudy"},`, 100) + `}`
+	tests := []struct {
+		name        string
+		compression string
+		value       string
+		wantGzip    bool
+	}{
+		{"large value", "gzip", large, true},
+		{"below the threshold", "gzip", `{"temp_c":12.5}`, false},
+		{"compression disabled", "none", large, false},
+		{"incompressible value", "gzip", randomText(2048), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			backend, _ := newTestMemoryCache(t, "2023-10-09T12:00:00Z")
+			cache := &instrumentedCache{backend: backend, compression: tt.compression, compressionThreshold: 1024}
+			if err := cache.Set(ctx, "key", tt.value, 0); err != nil {
+				t.Fatal(err)
+			}
+
+			stored, _ := backend.Get(ctx, "key")
+			if gzipped := stored[0] == cacheFormatGzip; gzipped != tt.wantGzip {
+				t.Errorf("stored with the gzip format byte = %v, want %v", gzipped, tt.wantGzip)
+			}
+			if tt.wantGzip && len(stored) >= len(tt.value) {
+				t.Errorf("stored %d bytes for a %d-byte value", len(stored), len(tt.value))
+			}
+			if value, err := cache.Get(ctx, "key"); err != nil || value != tt.value {
+				t.Errorf("Get returned %d bytes, %v; want the value back", len(value), err)
+			}
+			if values, err := cache.MGet(ctx, "key"); err != nil || values[0] != tt.value {
+				t.Errorf("MGet returned %d bytes, %v; want the value back", len(values[0]), err)
+			}
+		})
+	}
+}
+
+// randomText returns n random bytes that gzip can't shrink, starting like JSON so they never look compressed
+func randomText(n int) string {
+	buf := make([]byte, n)
+	rand.New(rand.NewSource(1)).Read(buf)
+	buf[0] = '{'
+	return string(buf)
+}
+
+func TestDecodeCacheValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		stored  string
+		want    string
+		wantErr bool
+	}{
+		{"entry written before compression", `{"temp_c":12.5}`, `{"temp_c":12.5}`, false},
+		{"empty entry", "", "", false},
+		{"corrupt gzip entry", string([]byte{cacheFormatGzip, 'x'}), "", true},
+	}
+	for _, tt := range tests {
+		got, err := decodeCacheValue(tt.stored)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("%s: decodeCacheValue = %q, %v; want %q, error %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestWithCacheRefresh(t *testing.T) {
+	ctx := context.Background()
+	backend, _ := newTestMemoryCache(t, "2023-10-09T12:00:00Z")
+	cache := &instrumentedCache{backend: backend, compression: "none"}
+	cache.Set(ctx, "refreshed", "1", 0)
+	cache.Set(ctx, "kept", "2", 0)
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want []string
+	}{
+		{"no refresh", ctx, []string{"1", "2"}},
+		{"refresh of one key", withCacheRefresh(ctx, "refreshed"), []string{"", "2"}},
+		{"refresh of every key", withCacheRefresh(ctx), []string{"", ""}},
+	}
+	for _, tt := range tests {
+		values, err := cache.MGet(tt.ctx, "refreshed", "kept")
+		if err != nil || values[0] != tt.want[0] || values[1] != tt.want[1] {
+			t.Errorf("%s: MGet = %q, %v; want %q", tt.name, values, err, tt.want)
+		}
+		if value, _ := cache.Get(tt.ctx, "refreshed"); value != tt.want[0] {
+			t.Errorf("%s: Get = %q, want %q", tt.name, value, tt.want[0])
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/afex/hystrix-go/hystrix"
+	"math"
+	"net/url"
+	"time"
+)
+
+// Sources of the weather reported for a match
+const (
+	sourceForecast    = "forecast"
+	sourceClimatology = "climatology"
+)
+
+// climatologyTTL caches an estimate for a day; the history it is built from doesn't change
+const climatologyTTL = 24 * time.Hour
+
+// beyondForecastHorizon reports whether a match is further away than the weather microservice can forecast
+func (g *Gateway) beyondForecastHorizon(ctx context.Context, start faceoff) bool {
+	today, _ := time.Parse(matchDateLayout, g.now(ctx).In(start.at.Location()).Format(matchDateLayout))
+	matchDay, _ := time.Parse(matchDateLayout, start.date())
+	return int(matchDay.Sub(today).Hours()/24) > g.config.ForecastHorizonDays
+}
+
+// climatology estimates the weather of a location on a date from the weather history of the same calendar
+// date in previous years. Each hour averages the numbers and takes the most frequent condition.
+// Years whose history can't be fetched are left out; it fails only when none can be.
+func (g *Gateway) climatology(ctx context.Context, location string, date string) (WeatherForecastResponse, error) {
+	estimates, errs := g.climatologies(ctx, []string{location}, []string{date})
+	return estimates[0], errs[0]
+}
+
+// climatologies makes the estimate of every location and date pair like climatology. The weather history of
+// every year of every estimate is resolved as one batch of lookups, so estimates sharing a year fetch it once
+// and no more than aggregationWorkers calls run at the same time.
+func (g *Gateway) climatologies(ctx context.Context, locations []string, dates []string) ([]WeatherForecastResponse, []error) {
+	estimates := make([]WeatherForecastResponse, len(locations))
+	errs := make([]error, len(locations))
+	cacheKeys := make([]string, len(locations))
+	for i := range locations {
+		cacheKeys[i] = "climatology_" + locations[i] + "_" + dates[i]
+	}
+	cached, cacheErr := g.cache.MGet(ctx, cacheKeys...)
+
+	var lookups weatherLookups
+	years := make([][]int, len(locations))
+	for i := range locations {
+		if cacheErr == nil && cached[i] != "" && json.Unmarshal([]byte(cached[i]), &estimates[i]) == nil {
+			continue
+		}
+		day, err := time.Parse(matchDateLayout, dates[i])
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		for year := 1; year <= g.config.ClimatologyYears; year++ {
+			yearDate := day.AddDate(-year, 0, 0).Format(matchDateLayout)
+			// The same cache key as getWeatherHistory
+			years[i] = append(years[i], lookups.add("weather_history_"+locations[i]+"_"+yearDate,
+				"/weather_history?"+url.Values{"location": {locations[i]}, "date": {yearDate}}.Encode()))
+		}
+	}
+	if len(lookups.keys) == 0 {
+		return estimates, errs
+	}
+
+	hystrix.ConfigureCommand("getWeatherHistory", hystrix.CommandConfig{
+		Timeout:               10000, // Timeout in milliseconds
+		MaxConcurrentRequests: 100,   // Maximum number of concurrent requests
+		ErrorPercentThreshold: 25,    // Error percentage threshold for circuit breaker
+	})
+	bodies, lookupErrs := g.resolveWeatherLookups(ctx, "getWeatherHistory", &lookups)
+
+	for i := range locations {
+		if years[i] == nil {
+			continue
+		}
+		histories := make([]yearHistory, len(years[i]))
+		for y, lookup := range years[i] {
+			histories[y].err = lookupErrs[lookup]
+			if histories[y].err != nil {
+				continue
+			}
+			var history WeatherHistoryResponse
+			if histories[y].err = decodeWeather(bodies[lookup], &history); histories[y].err == nil {
+				histories[y].hourly = history.HourlyWeather
+			}
+		}
+		estimates[i], errs[i] = combineClimatology(locations[i], dates[i], histories)
+		// An estimate missing some years is not cached, so the next request tries them again
+		if errs[i] == nil && !missingYears(histories) {
+			if body, err := json.Marshal(estimates[i]); err == nil {
+				g.cache.Set(context.Background(), cacheKeys[i], string(body), climatologyTTL)
+			}
+		}
+	}
+	return estimates, errs
+}
+
+// yearHistory is the weather history of one previous year of a climatological estimate
+type yearHistory struct {
+	hourly map[string]HourlyWeather
+	err    error
+}
+
+// missingYears reports whether the history of any year could not be fetched
+func missingYears(years []yearHistory) bool {
+	for _, year := range years {
+		if year.err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// combineClimatology averages the histories of the years into an estimate
+func combineClimatology(location string, date string, years []yearHistory) (WeatherForecastResponse, error) {
+	var estimate WeatherForecastResponse
+
+	// Totals of every hour across the years
+	type hourTotals struct {
+		samples      int
+		chanceOfRain int
+		cloud        int
+		tempC        float64
+		windMPH      float64
+		conditions   map[string]int
+	}
+	totals := make(map[string]*hourTotals)
+	var lastErr error
+	for _, year := range years {
+		if year.err != nil {
+			lastErr = year.err
+			continue
+		}
+		for hour, weather := range year.hourly {
+			total := totals[hour]
+			if total == nil {
+				total = &hourTotals{conditions: make(map[string]int)}
+				totals[hour] = total
+			}
+			total.samples++
+			total.chanceOfRain += weather.ChanceOfRain
+			total.cloud += weather.Cloud
+			total.tempC += weather.TempC
+			total.windMPH += weather.WindMPH
+			total.conditions[weather.Condition]++
+		}
+	}
+	if len(totals) == 0 {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("no weather history for %s around %s", location, date)
+		}
+		return estimate, lastErr
+	}
+
+	estimate = WeatherForecastResponse{ForecastDate: date, HourlyWeather: make(map[string]HourlyWeather)}
+	for hour, total := range totals {
+		condition, count := "", 0
+		for candidate, seen := range total.conditions {
+			// Ties go to the alphabetically first condition, so the estimate doesn't change between runs
+			if seen > count || (seen == count && candidate < condition) {
+				condition, count = candidate, seen
+			}
+		}
+		samples := float64(total.samples)
+		estimate.HourlyWeather[hour] = HourlyWeather{
+			ChanceOfRain: int(math.Round(float64(total.chanceOfRain) / samples)),
+			Cloud:        int(math.Round(float64(total.cloud) / samples)),
+			Condition:    condition,
+			TempC:        math.Round(total.tempC/samples*10) / 10,
+			WindMPH:      math.Round(total.windMPH/samples*10) / 10,
+		}
+	}
+
+	return estimate, nil
+}
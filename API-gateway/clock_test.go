@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestToday(t *testing.T) {
+	tests := []struct {
+		name        string
+		environment string
+		query       string
+		header      string
+		wantStatus  int
+		wantToday   string
+	}{
+		{"default timezone", "development", "", "", http.StatusOK, "2023-10-08"},
+		{"request timezone", "development", "?tz=UTC", "", http.StatusOK, "2023-10-09"},
+		{"pinned by header", "development", "", "2021-05-01T18:00:00Z", http.StatusOK, "2021-05-01"},
+		{"pinned date is midnight UTC", "development", "", "2021-05-01", http.StatusOK, "2021-04-30"},
+		{"pinned date and request timezone", "development", "?tz=Europe/Helsinki", "2021-05-01", http.StatusOK, "2021-05-01"},
+		{"header ignored in production", "production", "", "2021-05-01T18:00:00Z", http.StatusOK, "2023-10-08"},
+		{"invalid header", "development", "", "yesterday", http.StatusBadRequest, ""},
+		{"invalid timezone", "development", "?tz=Mars/Olympus", "", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 03:00 UTC is still the previous day in the default America/New_York timezone
+			g := newTestGateway(t, "2023-10-09T03:00:00Z")
+			g.config.Environment = tt.environment
+			handler := g.withClockOverride(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if today, ok := g.requestToday(w, r); ok {
+					w.Write([]byte(today))
+				}
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/meteo_for_today_matches"+tt.query, nil)
+			if tt.header != "" {
+				r.Header.Set("X-Gateway-Now", tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK && w.Body.String() != tt.wantToday {
+				t.Errorf("today = %q, want %q", w.Body.String(), tt.wantToday)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the gateway settings that can be changed through environment variables
+type Config struct {
+	Environment string // "production" or anything else (e.g. "staging", "test") to allow pinning the time
+	PinnedNow   string // Time the gateway clock starts at outside production, RFC 3339 or YYYY-MM-DD
+
+	CacheBackend  string // "redis", "memory" or "none"
+	RedisAddr     string
+	RedisPassword string
+	RedisDB       int
+
+	CacheCompression          string // "gzip" or "none"
+	CacheCompressionThreshold int    // Values smaller than this many bytes are stored as-is
+
+	AdminToken string // Token expected in the X-Admin-Token header of admin requests, empty disables the admin API
+
+	DefaultTimezone string // IANA timezone "today" is resolved in when a request has no tz parameter
+
+	AggregationWorkers int // How many weather lookups an aggregation runs at the same time
+	PastRangeMaxDays   int // Longest from/to range accepted by /past_matches_meteo
+
+	WarmSchedule string        // Cron expression for cache warming, empty disables it
+	WarmLockTTL  time.Duration // How long a gateway keeps the warming leadership without renewing it
+
+	FaceoffWindowBefore time.Duration // How far before faceoff the aggregations report hourly weather
+	FaceoffWindowAfter  time.Duration // How far after faceoff the aggregations report hourly weather
+	FaceoffDefaultHour  int           // Local start hour assumed for matches without a start time
+
+	AlertRules    []AlertRule // Thresholds the weather alerts are raised for
+	AlertSchedule string      // Cron expression for the alert scan, empty disables it
+
+	WebhookSchedule     string        // Cron expression for re-checking subscribed forecasts, empty disables it
+	WebhookMaxAttempts  int           // How many times a webhook delivery is tried before it goes to the dead-letter list
+	WebhookRetryBackoff time.Duration // Wait before the first retry, doubled after every failed attempt
+	WebhookLockTTL      time.Duration // How long a gateway keeps the webhook worker leadership without renewing it
+	WebhookSecretKey    string        // Key the subscription secrets are encrypted with, empty disables new subscriptions
+
+	LiveFeedInterval   time.Duration // How often the live feed refreshes today's matches and current weather
+	LiveAllowedOrigins []string      // Origins of the dashboards allowed to open the live feed besides the gateway's own
+
+	JobWorkers   int           // How many jobs a gateway runs at the same time, 0 disables the job workers
+	JobRetention time.Duration // How long jobs and their results are kept
+	JobMaxDays   int           // Longest from/to range accepted by a job
+
+	ForecastAccuracySchedule string        // Cron expression for comparing served forecasts with the weather history, empty disables it
+	ForecastAccuracyLockTTL  time.Duration // How long a gateway keeps the accuracy job leadership without renewing it
+
+	ForecastVersionRetention time.Duration // How long superseded versions of a forecast are kept
+
+	ForecastHorizonDays int // Matches further away than this many days get a climatological estimate instead of a forecast
+	ClimatologyYears    int // How many previous years a climatological estimate averages
+}
+
+// loadConfig reads the gateway configuration from the environment, falling back to defaults
+func loadConfig() Config {
+	return Config{
+		Environment: getEnv("GATEWAY_ENV", "production"),
+		PinnedNow:   getEnv("GATEWAY_NOW", ""),
+
+		CacheBackend:  getEnv("CACHE_BACKEND", "redis"),
+		RedisAddr:     getEnv("REDIS_ADDR", "redis-cache.pad:6379"),
+		RedisPassword: getEnv("REDIS_PASSWORD", ""),
+		RedisDB:       getEnvInt("REDIS_DB", 0),
+
+		CacheCompression:          getEnv("CACHE_COMPRESSION", "gzip"),
+		CacheCompressionThreshold: getEnvInt("CACHE_COMPRESSION_THRESHOLD", 1024),
+
+		AdminToken: getEnv("ADMIN_TOKEN", ""),
+
+		DefaultTimezone: getEnv("DEFAULT_TIMEZONE", "America/New_York"),
+
+		AggregationWorkers: getEnvInt("AGGREGATION_WORKERS", 5),
+		PastRangeMaxDays:   getEnvInt("PAST_RANGE_MAX_DAYS", 31),
+
+		WarmSchedule: getEnv("CACHE_WARM_SCHEDULE", ""),
+		WarmLockTTL:  getEnvDuration("CACHE_WARM_LOCK_TTL", 10*time.Minute),
+
+		FaceoffWindowBefore: getEnvDuration("FACEOFF_WINDOW_BEFORE", 2*time.Hour),
+		FaceoffWindowAfter:  getEnvDuration("FACEOFF_WINDOW_AFTER", time.Hour),
+		FaceoffDefaultHour:  getEnvInt("FACEOFF_DEFAULT_HOUR", 19),
+
+		AlertRules:    loadAlertRules(getEnv("ALERT_RULES", "")),
+		AlertSchedule: getEnv("ALERT_SCHEDULE", ""),
+
+		WebhookSchedule:     getEnv("WEBHOOK_SCHEDULE", "*/15 * * * *"),
+		WebhookMaxAttempts:  max(getEnvInt("WEBHOOK_MAX_ATTEMPTS", 5), 1),
+		WebhookRetryBackoff: getEnvDuration("WEBHOOK_RETRY_BACKOFF", 2*time.Second),
+		WebhookLockTTL:      getEnvDuration("WEBHOOK_LOCK_TTL", 10*time.Minute),
+		WebhookSecretKey:    getEnv("WEBHOOK_SECRET_KEY", ""),
+
+		LiveFeedInterval:   max(getEnvDuration("LIVE_FEED_INTERVAL", time.Minute), 5*time.Second),
+		LiveAllowedOrigins: getEnvList("LIVE_ALLOWED_ORIGINS"),
+
+		JobWorkers:   getEnvInt("JOB_WORKERS", 2),
+		JobRetention: getEnvDuration("JOB_RETENTION", 7*24*time.Hour),
+		JobMaxDays:   getEnvInt("JOB_MAX_DAYS", 366),
+
+		ForecastAccuracySchedule: getEnv("FORECAST_ACCURACY_SCHEDULE", "30 3 * * *"),
+		ForecastAccuracyLockTTL:  getEnvDuration("FORECAST_ACCURACY_LOCK_TTL", 30*time.Minute),
+
+		ForecastVersionRetention: getEnvDuration("FORECAST_VERSION_RETENTION", 30*24*time.Hour),
+
+		ForecastHorizonDays: getEnvInt("FORECAST_HORIZON_DAYS", 14),
+		ClimatologyYears:    max(getEnvInt("CLIMATOLOGY_YEARS", 5), 1),
+	}
+}
+
+// getEnv returns the value of an environment variable or the fallback when it is unset
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+// getEnvList returns a comma-separated environment variable as a list, without empty entries
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(getEnv(key, ""), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvInt returns an integer environment variable or the fallback when it is unset or invalid
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(getEnv(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// getEnvDuration returns a duration environment variable (e.g. "90s", "10m") or the fallback when it is unset or invalid
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(getEnv(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// matchStartLayout is the format of the start_time the matches microservice returns (UTC)
+const matchStartLayout = "2006-01-02T15:04Z07:00"
+
+// matchDateLayout is the dd.mm.yyyy format used for match dates and weather lookups
+const matchDateLayout = "02.01.2006"
+
+// FaceoffWeather summarises the weather at a match's venue around its start time
+type FaceoffWeather struct {
+	Faceoff   string `json:"faceoff"`   // Local start time at the venue, RFC 3339
+	Timezone  string `json:"timezone"`  // Timezone the hours are reported in
+	Estimated bool   `json:"estimated"` // True when the match has no start time and the default hour was assumed
+
+	Hour         string  `json:"hour"` // Forecast hour closest to faceoff
+	Condition    string  `json:"condition"`
+	TempC        float64 `json:"temp_c"`
+	WindMPH      float64 `json:"wind_mph"`
+	ChanceOfRain int     `json:"chance_of_rain"`
+	Cloud        int     `json:"cloud"`
+
+	// Extremes over the whole faceoff window
+	MinTempC        float64 `json:"min_temp_c"`
+	MaxTempC        float64 `json:"max_temp_c"`
+	MaxWindMPH      float64 `json:"max_wind_mph"`
+	MaxChanceOfRain int     `json:"max_chance_of_rain"`
+}
+
+// faceoff is a match's start time in the venue's timezone
+type faceoff struct {
+	at        time.Time
+	estimated bool
+}
+
+// date returns the local match date in the dd.mm.yyyy format the weather microservice expects
+func (f faceoff) date() string {
+	return f.at.Format(matchDateLayout)
+}
+
+// matchLocation returns the timezone of the match's venue. When the venue is unknown it falls back to the
+// default timezone rather than UTC, where an evening game in North America would land on the next day.
+func (g *Gateway) matchLocation(ctx context.Context, match Match) *time.Location {
+	venue, ok := g.venues.Get(ctx, match.VenueFullName)
+	if !ok {
+		venue, ok = g.venues.FindByCity(ctx, normalizeCity(match.City))
+	}
+	if !ok || venue.Timezone == "" {
+		return g.defaultLocation()
+	}
+
+	location, err := time.LoadLocation(venue.Timezone)
+	if err != nil {
+		fmt.Println("Error loading timezone", venue.Timezone, "for", venue.Name+":", err)
+		return g.defaultLocation()
+	}
+	return location
+}
+
+// defaultLocation returns the default timezone, or UTC if it can't be loaded
+func (g *Gateway) defaultLocation() *time.Location {
+	location, err := time.LoadLocation(g.config.DefaultTimezone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// matchFaceoff returns when a match starts at its venue. Matches without a start time are assumed
+// to start at the configured default hour on their date.
+func (g *Gateway) matchFaceoff(ctx context.Context, match Match) faceoff {
+	location := g.matchLocation(ctx, match)
+
+	if start, err := time.Parse(matchStartLayout, match.StartTime); err == nil {
+		return faceoff{at: start.In(location)}
+	}
+
+	day, err := time.ParseInLocation(matchDateLayout, match.Date, location)
+	if err != nil {
+		day = g.now(ctx).In(location)
+	}
+	return faceoff{
+		at:        time.Date(day.Year(), day.Month(), day.Day(), g.config.FaceoffDefaultHour, 0, 0, 0, location),
+		estimated: true,
+	}
+}
+
+// faceoffWindow picks the hourly entries from the configured window around faceoff and summarises them.
+// Hours that fall on the previous or next day are not part of the day's forecast and are left out.
+func (g *Gateway) faceoffWindow(start faceoff, hourly map[string]HourlyWeather) (map[string]HourlyWeather, *FaceoffWeather) {
+	if len(hourly) == 0 {
+		return nil, nil
+	}
+
+	from := start.at.Add(-g.config.FaceoffWindowBefore).Truncate(time.Hour)
+	to := start.at.Add(g.config.FaceoffWindowAfter)
+	nearest := start.at.Add(30 * time.Minute).Truncate(time.Hour)
+
+	window := make(map[string]HourlyWeather)
+	var summary *FaceoffWeather
+	for hour := from; !hour.After(to); hour = hour.Add(time.Hour) {
+		if hour.Format(matchDateLayout) != start.date() {
+			continue
+		}
+		key := hour.Format("15:04")
+		weather, ok := hourly[key]
+		if !ok {
+			continue
+		}
+		window[key] = weather
+
+		if summary == nil {
+			summary = &FaceoffWeather{
+				MinTempC: weather.TempC,
+				MaxTempC: weather.TempC,
+			}
+		}
+		summary.MinTempC = min(summary.MinTempC, weather.TempC)
+		summary.MaxTempC = max(summary.MaxTempC, weather.TempC)
+		summary.MaxWindMPH = max(summary.MaxWindMPH, weather.WindMPH)
+		summary.MaxChanceOfRain = max(summary.MaxChanceOfRain, weather.ChanceOfRain)
+
+		// The nearest hour may fall on the next day, so hours are compared as times rather than as "HH:MM"
+		if summary.Hour == "" || !hour.After(nearest) {
+			summary.Hour = key
+			summary.Condition = weather.Condition
+			summary.TempC = weather.TempC
+			summary.WindMPH = weather.WindMPH
+			summary.ChanceOfRain = weather.ChanceOfRain
+			summary.Cloud = weather.Cloud
+		}
+	}
+	if summary == nil {
+		return nil, nil
+	}
+
+	summary.Faceoff = start.at.Format(time.RFC3339)
+	summary.Timezone = start.at.Location().String()
+	summary.Estimated = start.estimated
+	return window, summary
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestMatchFaceoff(t *testing.T) {
+	g := newTestGateway(t, "2023-10-09T12:00:00Z")
+	g.config.FaceoffDefaultHour = 19
+	withVenues(t, g, Venue{Name: "TD Garden", City: "Boston", Timezone: "America/New_York"})
+
+	tests := []struct {
+		name          string
+		match         Match
+		want          string
+		wantEstimated bool
+	}{
+		{"start time at the venue", Match{VenueFullName: "TD Garden", StartTime: "2023-10-12T23:00Z"}, "2023-10-12T19:00:00-04:00", false},
+		{"late start on the previous local day", Match{City: "Boston", StartTime: "2023-10-13T02:30Z"}, "2023-10-12T22:30:00-04:00", false},
+		{"unknown venue in the default timezone", Match{City: "Nowhere", StartTime: "2023-10-12T23:00Z"}, "2023-10-12T19:00:00-04:00", false},
+		{"no start time", Match{VenueFullName: "TD Garden", Date: "12.10.2023"}, "2023-10-12T19:00:00-04:00", true},
+		{"no start time or date", Match{VenueFullName: "TD Garden"}, "2023-10-09T19:00:00-04:00", true},
+	}
+	for _, tt := range tests {
+		got := g.matchFaceoff(context.Background(), tt.match)
+		if got.at.Format(time.RFC3339) != tt.want || got.estimated != tt.wantEstimated {
+			t.Errorf("%s: matchFaceoff = %s (estimated %v), want %s (estimated %v)",
+				tt.name, got.at.Format(time.RFC3339), got.estimated, tt.want, tt.wantEstimated)
+		}
+	}
+}
+
+func TestFaceoffWindow(t *testing.T) {
+	g := newTestGateway(t, "2023-10-09T12:00:00Z")
+	g.config.FaceoffWindowBefore = 2 * time.Hour
+	g.config.FaceoffWindowAfter = time.Hour
+	boston, _ := time.LoadLocation("America/New_York")
+
+	hourly := make(map[string]HourlyWeather)
+	for hour := 0; hour < 24; hour++ {
+		hourly[time.Date(2023, 10, 12, hour, 0, 0, 0, time.UTC).Format("15:04")] = HourlyWeather{
+			TempC:        float64(hour),
+			WindMPH:      float64(30 - hour),
+			ChanceOfRain: hour * 4,
+		}
+	}
+
+	tests := []struct {
+		name        string
+		at          time.Time
+		hourly      map[string]HourlyWeather
+		wantHours   []string
+		wantNearest string
+		wantMinTemp float64
+		wantMaxTemp float64
+	}{
+		{"evening faceoff", time.Date(2023, 10, 12, 19, 0, 0, 0, boston), hourly,
+			[]string{"17:00", "18:00", "19:00", "20:00"}, "19:00", 17, 20},
+		{"faceoff past the half hour", time.Date(2023, 10, 12, 19, 40, 0, 0, boston), hourly,
+			[]string{"17:00", "18:00", "19:00", "20:00"}, "20:00", 17, 20},
+		{"window past midnight", time.Date(2023, 10, 12, 23, 30, 0, 0, boston), hourly,
+			[]string{"21:00", "22:00", "23:00"}, "23:00", 21, 23},
+		{"window before midnight", time.Date(2023, 10, 12, 0, 30, 0, 0, boston), hourly,
+			[]string{"00:00", "01:00"}, "01:00", 0, 1},
+		{"missing hours", time.Date(2023, 10, 12, 19, 0, 0, 0, boston), map[string]HourlyWeather{"18:00": hourly["18:00"]},
+			[]string{"18:00"}, "18:00", 18, 18},
+	}
+	for _, tt := range tests {
+		window, summary := g.faceoffWindow(faceoff{at: tt.at}, tt.hourly)
+		hours := make([]string, 0, len(window))
+		for hour := range window {
+			hours = append(hours, hour)
+		}
+		sort.Strings(hours)
+		if !reflect.DeepEqual(hours, tt.wantHours) {
+			t.Errorf("%s: window hours %v, want %v", tt.name, hours, tt.wantHours)
+			continue
+		}
+		if summary.Hour != tt.wantNearest || summary.MinTempC != tt.wantMinTemp || summary.MaxTempC != tt.wantMaxTemp {
+			t.Errorf("%s: summary hour %s, temperatures %v to %v; want %s, %v to %v",
+				tt.name, summary.Hour, summary.MinTempC, summary.MaxTempC, tt.wantNearest, tt.wantMinTemp, tt.wantMaxTemp)
+		}
+		if want := hourly[tt.wantHours[0]].ChanceOfRain; summary.MaxChanceOfRain < want {
+			t.Errorf("%s: max chance of rain %d, want at least %d", tt.name, summary.MaxChanceOfRain, want)
+		}
+		if summary.Faceoff != tt.at.Format(time.RFC3339) || summary.Timezone != "America/New_York" {
+			t.Errorf("%s: faceoff %s in %s", tt.name, summary.Faceoff, summary.Timezone)
+		}
+	}
+
+	if window, summary := g.faceoffWindow(faceoff{at: time.Date(2023, 10, 12, 19, 0, 0, 0, boston)}, nil); window != nil || summary != nil {
+		t.Errorf("faceoffWindow of an empty forecast = %v, %v; want nil", window, summary)
+	}
+}
+
+func TestRelativeToFaceoff(t *testing.T) {
+	boston, _ := time.LoadLocation("America/New_York")
+	faceoffAt := time.Date(2023, 10, 12, 19, 30, 0, 0, boston)
+
+	tests := []struct {
+		hour string
+		want string
+	}{
+		{"16:00", "3 h before faceoff"},
+		{"19:00", "at faceoff"},
+		{"20:00", "1 h after faceoff"},
+		{"23:00", "4 h after faceoff"},
+		{"noon", "around faceoff"},
+	}
+	for _, tt := range tests {
+		if got := relativeToFaceoff(faceoffAt, tt.hour); got != tt.want {
+			t.Errorf("relativeToFaceoff(%s) = %q, want %q", tt.hour, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+)
+
+// aggregationItemFields are the fields holding the per-match items of aggregation, team schedule and alert responses
+var aggregationItemFields = []string{"forecasts", "weather", "weather_history", "games", "alerts"}
+
+// matchFilter selects matches by the team, city, state, country, venue and name query parameters.
+// Comparisons ignore case; team and name match substrings of the match name ("Away at Home").
+type matchFilter struct {
+	team    string
+	city    string
+	state   string
+	country string
+	venue   string
+	name    string
+}
+
+// empty reports whether the filter lets every match through
+func (f matchFilter) empty() bool {
+	return f == matchFilter{}
+}
+
+// matches reports whether a match passes the filter
+func (f matchFilter) matches(match Match) bool {
+	if f.team != "" && !matchHasTeam(match, f.team) {
+		return false
+	}
+	if f.city != "" && !strings.EqualFold(normalizeCity(match.City), normalizeCity(f.city)) {
+		return false
+	}
+	if f.state != "" && !strings.EqualFold(normalizeCity(match.State), normalizeCity(f.state)) {
+		return false
+	}
+	if f.country != "" && !strings.EqualFold(strings.TrimSpace(match.Country), strings.TrimSpace(f.country)) {
+		return false
+	}
+	if f.venue != "" && !strings.EqualFold(strings.TrimSpace(match.VenueFullName), strings.TrimSpace(f.venue)) {
+		return false
+	}
+	if f.name != "" && !strings.Contains(strings.ToLower(match.Name), strings.ToLower(f.name)) {
+		return false
+	}
+	return true
+}
+
+// matchHasTeam reports whether one of the two sides of "Away at Home" contains the team name
+func matchHasTeam(match Match, team string) bool {
+	team = strings.ToLower(strings.TrimSpace(team))
+	for _, side := range strings.Split(match.Name, " at ") {
+		if strings.Contains(strings.ToLower(side), team) {
+			return true
+		}
+	}
+	return false
+}
+
+// responseView is the filter and hourly weather projection requested by a client
+type responseView struct {
+	filter matchFilter
+	fields map[string]bool // Hourly weather fields to keep, nil keeps all of them
+}
+
+// parseResponseView reads the filter and fields= projection from the query string
+func parseResponseView(r *http.Request) responseView {
+	return responseViewFromQuery(r.URL.Query())
+}
+
+// responseViewFromQuery reads the filter and fields= projection from query parameters
+func responseViewFromQuery(q url.Values) responseView {
+	view := responseView{filter: matchFilter{
+		team:    q.Get("team"),
+		city:    q.Get("city"),
+		state:   q.Get("state"),
+		country: q.Get("country"),
+		venue:   q.Get("venue"),
+		name:    q.Get("name"),
+	}}
+
+	if raw := q.Get("fields"); raw != "" {
+		view.fields = make(map[string]bool)
+		for _, field := range strings.Split(raw, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				view.fields[field] = true
+			}
+		}
+	}
+	return view
+}
+
+// empty reports whether the view leaves responses unchanged
+func (v responseView) empty() bool {
+	return v.filter.empty() && v.fields == nil
+}
+
+// withResponseView filters and projects the JSON a handler writes. The handler still reads and writes
+// the cache with the full response, so every filter shares the same cache entries. handlerParams are
+// query parameters the handler reads itself, like the team of /teams/schedule, which are not filters there.
+func withResponseView(next http.HandlerFunc, handlerParams ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		for _, param := range handlerParams {
+			query.Del(param)
+		}
+
+		// Streams apply the view to each item themselves
+		view := responseViewFromQuery(query)
+		if view.empty() || streamFormat(r) != "" {
+			next(w, r)
+			return
+		}
+
+		recorder := httptest.NewRecorder()
+		next(recorder, r)
+
+		body := recorder.Body.Bytes()
+		if recorder.Code == http.StatusOK || recorder.Code == http.StatusMultiStatus {
+			body = view.apply(body)
+		}
+
+		for key, values := range recorder.Header() {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(recorder.Code)
+		w.Write(body)
+	}
+}
+
+// apply filters the matches of a response body and trims its hourly weather. Bodies that aren't JSON
+// are returned unchanged.
+func (v responseView) apply(body []byte) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var response interface{}
+	if err := decoder.Decode(&response); err != nil {
+		return body
+	}
+
+	switch value := response.(type) {
+	case []interface{}:
+		response = v.filterItems(value)
+	case map[string]interface{}:
+		for _, field := range aggregationItemFields {
+			if items, ok := value[field].([]interface{}); ok {
+				value[field] = v.filterItems(items)
+			}
+		}
+	}
+	if v.fields != nil {
+		v.project(response)
+	}
+
+	filtered, err := json.Marshal(response)
+	if err != nil {
+		return body
+	}
+	return filtered
+}
+
+// filterItems keeps the items whose match passes the filter. An item is a match itself, an aggregation item
+// carrying its "match" or the "matches" played at its city, or an alert naming its "match" next to its uid and city.
+func (v responseView) filterItems(items []interface{}) []interface{} {
+	if v.filter.empty() {
+		return items
+	}
+
+	kept := []interface{}{}
+	for _, item := range items {
+		object, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		var candidates []Match
+		name, isName := object["match"].(string)
+		switch {
+		case isName:
+			candidates = decodeMatches([]interface{}{object})
+			for i := range candidates {
+				candidates[i].Name = name
+			}
+		case object["match"] != nil:
+			candidates = decodeMatches([]interface{}{object["match"]})
+		case object["matches"] != nil:
+			matches, _ := object["matches"].([]interface{})
+			candidates = decodeMatches(matches)
+		default:
+			candidates = decodeMatches([]interface{}{object})
+		}
+
+		for _, match := range candidates {
+			if v.filter.matches(match) {
+				kept = append(kept, item)
+				break
+			}
+		}
+	}
+	return kept
+}
+
+// decodeMatches converts generic JSON objects back to matches
+func decodeMatches(values []interface{}) []Match {
+	var matches []Match
+	for _, value := range values {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			continue
+		}
+		var match Match
+		if err := json.Unmarshal(encoded, &match); err == nil {
+			matches = append(matches, match)
+		}
+	}
+	return matches
+}
+
+// project walks the response and keeps only the requested fields of every hourly_weather entry
+func (v responseView) project(value interface{}) {
+	switch value := value.(type) {
+	case []interface{}:
+		for _, item := range value {
+			v.project(item)
+		}
+	case map[string]interface{}:
+		for key, child := range value {
+			hours, ok := child.(map[string]interface{})
+			if key != "hourly_weather" || !ok {
+				v.project(child)
+				continue
+			}
+			for _, hour := range hours {
+				if weather, ok := hour.(map[string]interface{}); ok {
+					for field := range weather {
+						if !v.fields[field] {
+							delete(weather, field)
+						}
+					}
+				}
+			}
+		}
+	}
+}
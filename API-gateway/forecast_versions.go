@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Cache key prefixes of the forecast history of a location and date, and of the lock that lets one
+// gateway at a time update it
+const (
+	forecastVersionsPrefix     = "forecast_versions_"
+	forecastVersionsLockPrefix = "forecast_versions_lock_"
+)
+
+// maxForecastVersions caps the history of one location and date; the oldest versions are dropped first
+const maxForecastVersions = 200
+
+// forecastVersionsLockTTL bounds how long a crashed gateway can hold a history's lock, and how long
+// another update waits for it
+const forecastVersionsLockTTL = 5 * time.Second
+
+// forecastVersionsLockRetry is how often an update waiting for a history's lock tries again
+const forecastVersionsLockRetry = 50 * time.Millisecond
+
+// ForecastVersion is a forecast as the weather microservice returned it, from RecordedAt until the next version
+type ForecastVersion struct {
+	Version       int                      `json:"version"`
+	RecordedAt    time.Time                `json:"recorded_at"`
+	HourlyWeather map[string]HourlyWeather `json:"hourly_weather,omitempty"`
+}
+
+// ForecastChange is one field of one hour that differs between two versions.
+// From is nil for an hour only the later version has, To for an hour only the earlier one has.
+type ForecastChange struct {
+	Hour  string      `json:"hour"`
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// ForecastVersionsResponse lists the versions of the forecast of a location and date
+type ForecastVersionsResponse struct {
+	Location string            `json:"location"`
+	Date     string            `json:"date"`
+	Versions []ForecastVersion `json:"versions"`
+}
+
+// ForecastDiffResponse compares two versions of a forecast
+type ForecastDiffResponse struct {
+	Location string           `json:"location"`
+	Date     string           `json:"date"`
+	From     ForecastVersion  `json:"from"`
+	To       ForecastVersion  `json:"to"`
+	Changes  []ForecastChange `json:"changes"`
+}
+
+// recordForecastVersion adds a forecast fetched from the weather microservice to the history of its location
+// and date, unless it is the same as the latest version. The history is updated in the background, so the
+// request that fetched the forecast doesn't wait for it. Nothing is recorded while the clock is pinned, as
+// versions are stamped with the time they were fetched.
+func (g *Gateway) recordForecastVersion(ctx context.Context, location string, date string, body []byte) {
+	var forecast WeatherForecastResponse
+	if location == "" || date == "" || g.clockPinned(ctx) || json.Unmarshal(body, &forecast) != nil || len(forecast.HourlyWeather) == 0 {
+		return
+	}
+	go g.addForecastVersion(context.WithoutCancel(ctx), location, date, g.now(ctx).UTC(), forecast.HourlyWeather)
+}
+
+// addForecastVersion appends a forecast to the history of its location and date under the history's lock.
+// Updates of different histories don't wait for each other.
+func (g *Gateway) addForecastVersion(ctx context.Context, location string, date string, now time.Time, hourly map[string]HourlyWeather) {
+	locker, ok := g.cache.(Locker)
+	if !ok {
+		return
+	}
+	lockKey := forecastVersionsLockPrefix + location + "_" + date
+	lockID := instanceID + "-" + newRandomID()
+	for waited := time.Duration(0); ; waited += forecastVersionsLockRetry {
+		acquired, err := locker.SetNX(ctx, lockKey, lockID, forecastVersionsLockTTL)
+		if err != nil {
+			return
+		}
+		if acquired {
+			break
+		}
+		if waited >= forecastVersionsLockTTL {
+			fmt.Println("Gave up recording a forecast version of", location, date+": the history is locked")
+			return
+		}
+		time.Sleep(forecastVersionsLockRetry)
+	}
+	defer locker.Release(ctx, lockKey, lockID)
+
+	versions := g.forecastVersions(ctx, location, date)
+	if len(versions) > 0 && reflect.DeepEqual(versions[len(versions)-1].HourlyWeather, hourly) {
+		return
+	}
+
+	next := 1
+	if len(versions) > 0 {
+		next = versions[len(versions)-1].Version + 1
+	}
+	versions = append(versions, ForecastVersion{Version: next, RecordedAt: now, HourlyWeather: hourly})
+
+	// A version is dropped once its successor is older than the retention, so every moment of the
+	// retention period still has its version
+	cutoff := now.Add(-g.config.ForecastVersionRetention)
+	first := 0
+	for first < len(versions)-1 && (versions[first+1].RecordedAt.Before(cutoff) || len(versions)-first > maxForecastVersions) {
+		first++
+	}
+	versions = versions[first:]
+
+	if encoded, err := json.Marshal(versions); err == nil {
+		g.cache.Set(ctx, forecastVersionsPrefix+location+"_"+date, string(encoded), g.config.ForecastVersionRetention)
+	}
+}
+
+// forecastVersions returns the history of a location and date, oldest version first
+func (g *Gateway) forecastVersions(ctx context.Context, location string, date string) []ForecastVersion {
+	var versions []ForecastVersion
+	if stored, err := g.cache.Get(ctx, forecastVersionsPrefix+location+"_"+date); err == nil {
+		json.Unmarshal([]byte(stored), &versions)
+	}
+	return versions
+}
+
+// diffForecastVersions lists the fields that changed from one version to another, by hour
+func diffForecastVersions(from, to ForecastVersion) []ForecastChange {
+	hours := make(map[string]bool)
+	for hour := range from.HourlyWeather {
+		hours[hour] = true
+	}
+	for hour := range to.HourlyWeather {
+		hours[hour] = true
+	}
+	sorted := make([]string, 0, len(hours))
+	for hour := range hours {
+		sorted = append(sorted, hour)
+	}
+	sort.Strings(sorted)
+
+	changes := []ForecastChange{}
+	for _, hour := range sorted {
+		before, hadBefore := from.HourlyWeather[hour]
+		after, hasAfter := to.HourlyWeather[hour]
+		fields := []struct {
+			name          string
+			before, after interface{}
+		}{
+			{"temp_c", before.TempC, after.TempC},
+			{"condition", before.Condition, after.Condition},
+			{"wind_mph", before.WindMPH, after.WindMPH},
+			{"cloud", before.Cloud, after.Cloud},
+			{"chance_of_rain", before.ChanceOfRain, after.ChanceOfRain},
+		}
+		for _, field := range fields {
+			if hadBefore && hasAfter && field.before == field.after {
+				continue
+			}
+			change := ForecastChange{Hour: hour, Field: field.name, From: field.before, To: field.after}
+			if !hadBefore {
+				change.From = nil
+			}
+			if !hasAfter {
+				change.To = nil
+			}
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// getForecastVersions handles /forecast_versions. The forecast is selected with location and date (dd.mm.yyyy),
+// or with the uid of a match (and its date for a past match). It returns every version, the version that was
+// current at the time given by at, or the diff between the versions given by from_version and to_version.
+func (g *Gateway) getForecastVersions(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	location := g.canonicalLocation(r.Context(), query.Get("location"))
+	date := query.Get("date")
+
+	if uid := query.Get("uid"); uid != "" {
+		today, ok := g.requestToday(w, r)
+		if !ok {
+			return
+		}
+		match, found, err := g.findMatch(r.Context(), uid, today, date)
+		if err != nil && !found {
+			http.Error(w, "Error making request to matches microservice for match "+uid, http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "Match not found", http.StatusNotFound)
+			return
+		}
+		location = g.resolveLocation(r.Context(), match)
+		date = g.matchFaceoff(r.Context(), match).date()
+	}
+
+	if location == "" || date == "" {
+		http.Error(w, "Location and date, or uid, are required parameters", http.StatusBadRequest)
+		return
+	}
+
+	versions := g.forecastVersions(r.Context(), location, date)
+
+	switch {
+	case query.Get("at") != "":
+		at, err := parseClockTime(query.Get("at"))
+		if err != nil {
+			http.Error(w, "At must be an RFC 3339 time or a YYYY-MM-DD date", http.StatusBadRequest)
+			return
+		}
+		// The current version is the last one recorded at or before the time
+		index := sort.Search(len(versions), func(i int) bool { return versions[i].RecordedAt.After(at) }) - 1
+		if index < 0 {
+			http.Error(w, "No forecast version was recorded before "+at.Format(time.RFC3339), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, versions[index])
+
+	case query.Get("from_version") != "" || query.Get("to_version") != "":
+		from, fromOK := findForecastVersion(versions, query.Get("from_version"))
+		to, toOK := findForecastVersion(versions, query.Get("to_version"))
+		if !fromOK || !toOK {
+			http.Error(w, "Forecast version not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, ForecastDiffResponse{
+			Location: location,
+			Date:     date,
+			From:     from,
+			To:       to,
+			Changes:  diffForecastVersions(from, to),
+		})
+
+	default:
+		response := ForecastVersionsResponse{Location: location, Date: date, Versions: []ForecastVersion{}}
+		// The list only has the version numbers and times; each forecast is read with at or a diff
+		for _, version := range versions {
+			response.Versions = append(response.Versions, ForecastVersion{Version: version.Version, RecordedAt: version.RecordedAt})
+		}
+		writeJSON(w, http.StatusOK, response)
+	}
+}
+
+// findForecastVersion returns the version with the given number
+func findForecastVersion(versions []ForecastVersion, raw string) (ForecastVersion, bool) {
+	number, err := strconv.Atoi(raw)
+	if err != nil {
+		return ForecastVersion{}, false
+	}
+	for _, version := range versions {
+		if version.Version == number {
+			return version, true
+		}
+	}
+	return ForecastVersion{}, false
+}
@@ -0,0 +1,31 @@
+package main
+
+// Gateway holds the dependencies shared by the HTTP handlers
+type Gateway struct {
+	config Config
+	clock  Clock
+	cache  Cache
+	venues *VenueRegistry
+	live   *liveFeeds
+}
+
+// NewGateway creates a gateway with the cache backend selected in the configuration
+func NewGateway(cfg Config) (*Gateway, error) {
+	clock, err := newClock(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	cache, err := newCache(cfg, clock)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Gateway{
+		config: cfg,
+		clock:  clock,
+		cache:  cache,
+		venues: newVenueRegistry(cache),
+		live:   newLiveFeeds(),
+	}, nil
+}
@@ -0,0 +1,543 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Cache keys of the jobs, their per-day results and the lists shared by the job workers of every gateway
+const (
+	jobKeyPrefix    = "job_"
+	jobDayPrefix    = "job_day_"
+	jobLeasePrefix  = "job_lease_"
+	jobCancelPrefix = "job_cancel_"
+	jobQueueKey     = "job_queue"
+	jobRunningKey   = "job_running"
+)
+
+// A running job holds a lease that its worker renews on every heartbeat. A job whose lease expired,
+// because its gateway stopped, is put back in the queue and resumes from the days it had not finished.
+// A worker that can't renew its lease stops, since the job may already be running elsewhere.
+const (
+	jobLeaseTTL       = time.Minute
+	jobHeartbeat      = 20 * time.Second
+	jobPollInterval   = 2 * time.Second
+	jobReaperInterval = time.Minute
+)
+
+// Job statuses; succeeded, failed and cancelled jobs are finished
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+// errJobLeaseLost stops a job whose worker could not renew its lease
+var errJobLeaseLost = errors.New("job lease lost")
+
+// JobSpec describes the aggregation a job runs. The only type so far is "past_matches_meteo",
+// the matches played from one date to another with their weather history.
+type JobSpec struct {
+	Type    string `json:"type"`
+	From    string `json:"from"` // dd.mm.yyyy
+	To      string `json:"to"`   // dd.mm.yyyy
+	FullDay bool   `json:"full_day,omitempty"`
+}
+
+// dates validates the spec and returns the days it covers
+func (s JobSpec) dates(maxDays int) ([]string, error) {
+	if s.Type != "past_matches_meteo" {
+		return nil, fmt.Errorf("unknown job type %q", s.Type)
+	}
+	from, err := time.Parse(matchDateLayout, s.From)
+	if err != nil {
+		return nil, fmt.Errorf("from must be a date in the dd.mm.yyyy format")
+	}
+	to, err := time.Parse(matchDateLayout, s.To)
+	if err != nil {
+		return nil, fmt.Errorf("to must be a date in the dd.mm.yyyy format")
+	}
+	if to.Before(from) {
+		return nil, fmt.Errorf("to must not be before from")
+	}
+
+	days := int(to.Sub(from).Hours()/24) + 1
+	if days > maxDays {
+		return nil, fmt.Errorf("a job can't cover more than %d days", maxDays)
+	}
+	dates := make([]string, days)
+	for i := range dates {
+		dates[i] = from.AddDate(0, 0, i).Format(matchDateLayout)
+	}
+	return dates, nil
+}
+
+// JobProgress counts the days of a job
+type JobProgress struct {
+	Days       int `json:"days"`
+	DaysDone   int `json:"days_done"`
+	DaysFailed int `json:"days_failed"`
+	Matches    int `json:"matches"`
+}
+
+// JobDay summarises a finished day of a job; its matches are in the job's result
+type JobDay struct {
+	Date    string     `json:"date"`
+	Matches int        `json:"matches"`
+	Partial bool       `json:"partial"`
+	Error   *ItemError `json:"error,omitempty"`
+}
+
+// Job is an aggregation queued through POST /jobs and run by the job workers
+type Job struct {
+	ID         string      `json:"id"`
+	Status     string      `json:"status"`
+	Spec       JobSpec     `json:"spec"`
+	Progress   JobProgress `json:"progress"`
+	Partial    bool        `json:"partial"`
+	Error      string      `json:"error,omitempty"`
+	Days       []JobDay    `json:"days"`
+	CreatedAt  time.Time   `json:"created_at"`
+	StartedAt  *time.Time  `json:"started_at,omitempty"`
+	FinishedAt *time.Time  `json:"finished_at,omitempty"`
+}
+
+// finished reports whether the job is done, one way or another
+func (j Job) finished() bool {
+	return j.Status == jobSucceeded || j.Status == jobFailed || j.Status == jobCancelled
+}
+
+// JobResult is the downloadable result of a job. While the job runs it holds the days finished so far.
+type JobResult struct {
+	ID        string                      `json:"id"`
+	Status    string                      `json:"status"`
+	Complete  bool                        `json:"complete"`
+	Partial   bool                        `json:"partial"`
+	From      string                      `json:"from"`
+	To        string                      `json:"to"`
+	Weather   []CombinedPastMatchResponse `json:"weather_history"`
+	DayErrors []DayError                  `json:"day_errors,omitempty"`
+}
+
+// loadJob reads a job, or returns errCacheMiss when there is none with this ID
+func (g *Gateway) loadJob(ctx context.Context, id string) (Job, error) {
+	var job Job
+	stored, err := g.cache.Get(ctx, jobKeyPrefix+id)
+	if err != nil {
+		return job, err
+	}
+	err = json.Unmarshal([]byte(stored), &job)
+	return job, err
+}
+
+// saveJob stores a job for the retention period
+func (g *Gateway) saveJob(ctx context.Context, job Job) error {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return g.cache.Set(ctx, jobKeyPrefix+job.ID, string(body), g.config.JobRetention)
+}
+
+// jobsHandler serves the job API: POST /jobs, GET /jobs/{id}, GET /jobs/{id}/result,
+// and DELETE /jobs/{id} or POST /jobs/{id}/cancel
+func (g *Gateway) jobsHandler(w http.ResponseWriter, r *http.Request) {
+	queue := g.cacheQueue()
+	if queue == nil {
+		http.Error(w, "Jobs need the redis or memory cache backend", http.StatusServiceUnavailable)
+		return
+	}
+
+	id, action, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs"), "/"), "/")
+
+	if id == "" {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		g.createJob(w, r, queue)
+		return
+	}
+
+	job, err := g.loadJob(r.Context(), id)
+	if err == errCacheMiss {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error reading job", http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, job)
+	case action == "result" && r.Method == http.MethodGet:
+		g.getJobResult(w, r, job)
+	case (action == "" && r.Method == http.MethodDelete) || (action == "cancel" && r.Method == http.MethodPost):
+		g.cancelJob(w, r, queue, job)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// createJob validates a job spec, stores the job and queues it
+func (g *Gateway) createJob(w http.ResponseWriter, r *http.Request, queue Queue) {
+	var spec JobSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		http.Error(w, "Error parsing job spec", http.StatusBadRequest)
+		return
+	}
+	dates, err := spec.dates(g.config.JobMaxDays)
+	if err != nil {
+		http.Error(w, "Invalid job spec: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	job := Job{
+		ID:        newRandomID(),
+		Status:    jobQueued,
+		Spec:      spec,
+		Progress:  JobProgress{Days: len(dates)},
+		Days:      []JobDay{},
+		CreatedAt: g.now(r.Context()).UTC(),
+	}
+	if err := g.saveJob(r.Context(), job); err != nil {
+		http.Error(w, "Error saving job", http.StatusInternalServerError)
+		return
+	}
+	if err := queue.Push(r.Context(), jobQueueKey, job.ID); err != nil {
+		http.Error(w, "Error queueing job", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// cancelJob cancels a queued job right away. A running job is flagged, and its worker stops
+// at its next heartbeat; the days finished until then stay in the result.
+func (g *Gateway) cancelJob(w http.ResponseWriter, r *http.Request, queue Queue, job Job) {
+	if job.finished() {
+		http.Error(w, "Job is already "+job.Status, http.StatusConflict)
+		return
+	}
+
+	// Only the caller that takes the job off the queue may update it; otherwise a worker already has it
+	removed, err := queue.Remove(r.Context(), jobQueueKey, job.ID)
+	if err != nil {
+		http.Error(w, "Error cancelling job", http.StatusInternalServerError)
+		return
+	}
+	if removed {
+		now := g.now(r.Context()).UTC()
+		job.Status = jobCancelled
+		job.FinishedAt = &now
+		if err := g.saveJob(r.Context(), job); err != nil {
+			http.Error(w, "Error cancelling job", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+		return
+	}
+
+	if err := g.cache.Set(r.Context(), jobCancelPrefix+job.ID, "1", g.config.JobRetention); err != nil {
+		http.Error(w, "Error cancelling job", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// getJobResult returns the matches of the days a job has finished, in date order.
+// Once the job is finished the result is sent as a file download.
+func (g *Gateway) getJobResult(w http.ResponseWriter, r *http.Request, job Job) {
+	result := JobResult{
+		ID:       job.ID,
+		Status:   job.Status,
+		Complete: job.finished(),
+		Partial:  job.Partial,
+		From:     job.Spec.From,
+		To:       job.Spec.To,
+		Weather:  []CombinedPastMatchResponse{},
+	}
+
+	days := append([]JobDay(nil), job.Days...)
+	sort.Slice(days, func(i, j int) bool {
+		a, _ := time.Parse(matchDateLayout, days[i].Date)
+		b, _ := time.Parse(matchDateLayout, days[j].Date)
+		return a.Before(b)
+	})
+
+	keys := make([]string, len(days))
+	for i, day := range days {
+		keys[i] = jobDayPrefix + job.ID + "_" + day.Date
+	}
+	var stored []string
+	if len(keys) > 0 {
+		var err error
+		if stored, err = g.cache.MGet(r.Context(), keys...); err != nil {
+			http.Error(w, "Error reading job result", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	for i, day := range days {
+		if day.Error != nil {
+			result.DayErrors = append(result.DayErrors, DayError{Date: day.Date, Error: day.Error})
+			continue
+		}
+		var response PastMatchesMeteoResponse
+		if err := json.Unmarshal([]byte(stored[i]), &response); err != nil {
+			result.DayErrors = append(result.DayErrors, DayError{Date: day.Date, Error: newItemError("Error reading job result", err)})
+			result.Partial = true
+			continue
+		}
+		result.Weather = append(result.Weather, response.Weather...)
+	}
+
+	if result.Complete {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"job-%s.json\"", job.ID))
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// startJobWorkers starts the configured number of job workers, and the reaper that requeues the jobs
+// of gateways that stopped while running them
+func (g *Gateway) startJobWorkers() {
+	queue := g.cacheQueue()
+	if g.config.JobWorkers <= 0 || queue == nil {
+		return
+	}
+
+	for worker := 0; worker < g.config.JobWorkers; worker++ {
+		go func() {
+			for {
+				// The job goes straight to the running list, so a gateway stopping before it takes the lease
+				// leaves the job for the reaper instead of losing it
+				id, err := queue.Move(context.Background(), jobQueueKey, jobRunningKey)
+				if err != nil {
+					if err != errCacheMiss {
+						fmt.Println("Error reading the job queue:", err)
+					}
+					time.Sleep(jobPollInterval)
+					continue
+				}
+				g.runJob(queue, id)
+			}
+		}()
+	}
+
+	go func() {
+		suspects := make(map[string]bool)
+		for {
+			suspects = g.requeueAbandonedJobs(queue, suspects)
+			time.Sleep(jobReaperInterval)
+		}
+	}()
+
+	fmt.Println("Job workers started:", g.config.JobWorkers)
+}
+
+// requeueAbandonedJobs puts the running jobs without a lease back in the queue. A job that was just moved
+// to the running list has no lease yet, so only the suspects of the previous scan that still have none are
+// requeued; it returns the suspects of this scan.
+func (g *Gateway) requeueAbandonedJobs(queue Queue, previous map[string]bool) map[string]bool {
+	ctx := context.Background()
+	suspects := make(map[string]bool)
+	ids, err := queue.Range(ctx, jobRunningKey)
+	if err != nil {
+		fmt.Println("Error reading the running jobs:", err)
+		return previous
+	}
+
+	for _, id := range ids {
+		if _, err := g.cache.Get(ctx, jobLeasePrefix+id); err != errCacheMiss {
+			continue
+		}
+		if !previous[id] {
+			suspects[id] = true
+			continue
+		}
+		// Only the gateway that takes the job off the running list requeues it
+		if removed, err := queue.Remove(ctx, jobRunningKey, id); err != nil || !removed {
+			continue
+		}
+		fmt.Println("Requeueing abandoned job", id)
+		queue.Push(ctx, jobQueueKey, id)
+	}
+	return suspects
+}
+
+// runJob runs a job moved to the running list, skipping the days it finished before being interrupted
+func (g *Gateway) runJob(queue Queue, id string) {
+	lease := instanceID + "-" + newRandomID()
+	if !g.acquireJobLease(id, lease) {
+		// Another worker holds the job; its worker or the reaper cleans the running list up
+		return
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	defer func() {
+		// A worker that lost its lease leaves the job to the reaper or to the worker that took it over
+		if context.Cause(ctx) == errJobLeaseLost {
+			return
+		}
+		queue.Remove(context.Background(), jobRunningKey, id)
+		g.cache.Delete(context.Background(), jobCancelPrefix+id)
+		if locker, ok := g.cache.(Locker); ok {
+			locker.Release(context.Background(), jobLeasePrefix+id, lease)
+		}
+	}()
+
+	job, err := g.loadJob(context.Background(), id)
+	if err != nil {
+		fmt.Println("Error reading job", id+":", err)
+		return
+	}
+	if job.finished() {
+		return
+	}
+	if _, err := g.cache.Get(context.Background(), jobCancelPrefix+id); err == nil {
+		g.finishJob(job, jobCancelled, "")
+		return
+	}
+
+	go g.jobHeartbeat(ctx, cancel, id, lease)
+
+	now := g.clock.Now().UTC()
+	job.Status = jobRunning
+	if job.StartedAt == nil {
+		job.StartedAt = &now
+	}
+	g.saveJob(ctx, job)
+
+	dates, err := job.Spec.dates(g.config.JobMaxDays)
+	if err != nil {
+		g.finishJob(job, jobFailed, err.Error())
+		return
+	}
+	done := make(map[string]bool)
+	for _, day := range job.Days {
+		done[day.Date] = true
+	}
+	var remaining []string
+	for _, date := range dates {
+		if !done[date] {
+			remaining = append(remaining, date)
+		}
+	}
+
+	// Every day runs its own weather fan-out, so only as many days run at once as the weather command allows
+	fanOutEach(ctx, len(remaining), weatherCommandMaxConcurrency/g.aggregationWorkers(), func(ctx context.Context, i int) pastMeteoDay {
+		if ctx.Err() != nil {
+			return pastMeteoDay{err: newItemError("Job cancelled", ctx.Err())}
+		}
+		response, err := g.cachedPastMatchesMeteoForDate(ctx, remaining[i], job.Spec.FullDay)
+		return pastMeteoDay{response: response, err: err}
+	}, func(i int, result pastMeteoDay) {
+		// Days interrupted by a cancellation are not recorded
+		if ctx.Err() != nil {
+			return
+		}
+		day := JobDay{Date: remaining[i], Error: result.err}
+		if result.err == nil {
+			body, err := json.Marshal(result.response)
+			if err == nil {
+				err = g.cache.Set(ctx, jobDayPrefix+id+"_"+day.Date, string(body), g.config.JobRetention)
+			}
+			if err != nil {
+				day.Error = newItemError("Error saving job result", err)
+			}
+		}
+
+		if day.Error != nil {
+			job.Progress.DaysFailed++
+			job.Partial = true
+		} else {
+			day.Matches = len(result.response.Weather)
+			day.Partial = result.response.Partial
+			job.Progress.Matches += day.Matches
+			job.Partial = job.Partial || day.Partial
+		}
+		job.Progress.DaysDone++
+		job.Days = append(job.Days, day)
+		g.saveJob(ctx, job)
+	})
+
+	if context.Cause(ctx) == errJobLeaseLost {
+		fmt.Println("Stopped job", id, "after losing its lease")
+		return
+	}
+	if ctx.Err() != nil {
+		g.finishJob(job, jobCancelled, "")
+		return
+	}
+	g.finishJob(job, jobSucceeded, "")
+}
+
+// acquireJobLease takes a job for this worker
+func (g *Gateway) acquireJobLease(id string, lease string) bool {
+	locker, ok := g.cache.(Locker)
+	if !ok {
+		return false
+	}
+	acquired, err := locker.SetNX(context.Background(), jobLeasePrefix+id, lease, jobLeaseTTL)
+	if err != nil {
+		fmt.Println("Error acquiring the lease of job", id+":", err)
+	}
+	return acquired
+}
+
+// jobHeartbeat renews a running job's lease and cancels the job when a cancellation was requested.
+// The lease is renewed only while the worker still holds it; otherwise the job is stopped with errJobLeaseLost.
+func (g *Gateway) jobHeartbeat(ctx context.Context, cancel context.CancelCauseFunc, id string, lease string) {
+	locker, ok := g.cache.(Locker)
+	if !ok {
+		return
+	}
+
+	ticker := time.NewTicker(jobHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := g.cache.Get(ctx, jobCancelPrefix+id); err == nil {
+			cancel(nil)
+			return
+		}
+		renewed, err := locker.Renew(ctx, jobLeasePrefix+id, lease, jobLeaseTTL)
+		if err != nil {
+			fmt.Println("Error renewing the lease of job", id+":", err)
+		}
+		if err != nil || !renewed {
+			cancel(errJobLeaseLost)
+			return
+		}
+	}
+}
+
+// finishJob records the final status of a job
+func (g *Gateway) finishJob(job Job, status string, message string) {
+	now := g.clock.Now().UTC()
+	job.Status = status
+	job.Error = message
+	job.FinishedAt = &now
+	if err := g.saveJob(context.Background(), job); err != nil {
+		fmt.Println("Error saving job", job.ID+":", err)
+	}
+	fmt.Printf("Job %s %s: %d of %d days done, %d failed\n", job.ID, status, job.Progress.DaysDone, job.Progress.Days, job.Progress.DaysFailed)
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// liveFeedPollTimeout bounds one poll of today's matches and current weather
+const liveFeedPollTimeout = 30 * time.Second
+
+// LiveMessage is pushed to live feed clients. A snapshot is sent on connect and after every subscription
+// change; then "weather" carries the cities whose current weather changed and "matches" today's new match list.
+type LiveMessage struct {
+	Type    string                `json:"type"` // "snapshot", "weather", "matches" or "error"
+	Date    string                `json:"date"`
+	Matches []Match               `json:"matches,omitempty"`
+	Weather []CityWeatherResponse `json:"weather,omitempty"`
+	Error   string                `json:"error,omitempty"`
+}
+
+// LiveSubscription selects the cities and matches a client receives; an empty subscription selects everything
+type LiveSubscription struct {
+	Cities []string `json:"cities"`
+	UIDs   []string `json:"uids"`
+}
+
+// empty reports whether the subscription selects everything
+func (s LiveSubscription) empty() bool {
+	return len(s.Cities) == 0 && len(s.UIDs) == 0
+}
+
+// selectsMatch reports whether a match is in one of the subscribed cities or is one of the subscribed matches
+func (s LiveSubscription) selectsMatch(match Match) bool {
+	if s.empty() {
+		return true
+	}
+	for _, city := range s.Cities {
+		if strings.EqualFold(city, match.City) {
+			return true
+		}
+	}
+	for _, uid := range s.UIDs {
+		if uid == match.UID {
+			return true
+		}
+	}
+	return false
+}
+
+// selectsCity reports whether the weather of a city is subscribed to, directly or through one of its matches
+func (s LiveSubscription) selectsCity(weather CityWeatherResponse) bool {
+	if s.empty() {
+		return true
+	}
+	for _, city := range s.Cities {
+		if strings.EqualFold(city, weather.City) {
+			return true
+		}
+	}
+	for _, match := range weather.Matches {
+		if s.selectsMatch(match) {
+			return true
+		}
+	}
+	return false
+}
+
+// liveClient is one WebSocket connection. Messages are queued on send and written by the client's own goroutine.
+type liveClient struct {
+	conn         *websocket.Conn
+	send         chan LiveMessage
+	mu           sync.Mutex
+	subscription LiveSubscription
+}
+
+// liveFeed polls today's matches and current weather in one timezone for the clients connected to it
+type liveFeed struct {
+	location *time.Location
+	clients  map[*liveClient]bool
+	stop     chan struct{}
+
+	// Latest poll, compared with the next one to push only what changed
+	date     string
+	matches  []Match
+	weather  map[string][]byte // Encoded current weather by city
+	response *TodayMatchesWeatherResponse
+}
+
+// liveFeeds holds the running feeds by timezone name. A feed starts with its first client and stops with its last.
+type liveFeeds struct {
+	mu    sync.Mutex
+	feeds map[string]*liveFeed
+}
+
+func newLiveFeeds() *liveFeeds {
+	return &liveFeeds{feeds: make(map[string]*liveFeed)}
+}
+
+// getLiveFeed handles /live/today. Clients subscribe with the city and uid query parameters, and can change
+// their subscription at any time by sending a LiveSubscription as JSON.
+func (g *Gateway) getLiveFeed(w http.ResponseWriter, r *http.Request) {
+	location, err := g.requestLocation(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid timezone: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	upgrader := websocket.Upgrader{CheckOrigin: g.liveOriginAllowed}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already written the error response
+		return
+	}
+
+	client := &liveClient{
+		conn: conn,
+		send: make(chan LiveMessage, 16),
+		subscription: LiveSubscription{
+			Cities: r.URL.Query()["city"],
+			UIDs:   r.URL.Query()["uid"],
+		},
+	}
+	feed := g.joinLiveFeed(location, client)
+	defer g.leaveLiveFeed(location, client)
+
+	go client.writeMessages()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var subscription LiveSubscription
+		err = json.Unmarshal(data, &subscription)
+		if err == nil {
+			client.mu.Lock()
+			client.subscription = subscription
+			client.mu.Unlock()
+		}
+
+		g.live.mu.Lock()
+		if err != nil {
+			client.push(LiveMessage{Type: "error", Error: "Subscriptions must be JSON objects with cities and uids"})
+		} else if feed.response != nil {
+			client.push(client.snapshot(feed))
+		}
+		g.live.mu.Unlock()
+	}
+}
+
+// liveOriginAllowed accepts WebSocket connections from the gateway's own origin and the configured dashboards,
+// so other sites can't open the feed with their visitors' browsers. Clients that send no Origin are not browsers.
+func (g *Gateway) liveOriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if parsed, err := url.Parse(origin); err == nil && strings.EqualFold(parsed.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range g.config.LiveAllowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// joinLiveFeed adds a client to the feed of its timezone, starting the feed if it is the first client.
+// A client joining a running feed gets the latest snapshot right away.
+func (g *Gateway) joinLiveFeed(location *time.Location, client *liveClient) *liveFeed {
+	g.live.mu.Lock()
+	defer g.live.mu.Unlock()
+
+	feed, ok := g.live.feeds[location.String()]
+	if !ok {
+		feed = &liveFeed{
+			location: location,
+			clients:  make(map[*liveClient]bool),
+			stop:     make(chan struct{}),
+		}
+		g.live.feeds[location.String()] = feed
+		go g.runLiveFeed(feed)
+	} else if feed.response != nil {
+		client.push(client.snapshot(feed))
+	}
+	feed.clients[client] = true
+	return feed
+}
+
+// leaveLiveFeed removes a client and stops its feed when no client is left
+func (g *Gateway) leaveLiveFeed(location *time.Location, client *liveClient) {
+	g.live.mu.Lock()
+	defer g.live.mu.Unlock()
+
+	feed := g.live.feeds[location.String()]
+	delete(feed.clients, client)
+	close(client.send)
+	if len(feed.clients) == 0 {
+		close(feed.stop)
+		delete(g.live.feeds, location.String())
+	}
+}
+
+// runLiveFeed polls the feed on the configured interval until its last client leaves
+func (g *Gateway) runLiveFeed(feed *liveFeed) {
+	ticker := time.NewTicker(g.config.LiveFeedInterval)
+	defer ticker.Stop()
+
+	for {
+		g.pollLiveFeed(feed)
+		select {
+		case <-feed.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pollLiveFeed refreshes today's matches and current weather and pushes the changes to the feed's clients.
+// "today" is resolved on every poll, so a feed left open overnight moves on to the next day's matches.
+// The polls read through cache entries that expire after one interval, so the microservices are called
+// once per interval however many feeds and gateways are polling.
+func (g *Gateway) pollLiveFeed(feed *liveFeed) {
+	ctx, cancel := context.WithTimeout(context.Background(), liveFeedPollTimeout)
+	defer cancel()
+
+	today := g.now(ctx).In(feed.location).Format(dateKeyLayout)
+	response, err := g.todayMatchesWeather(ctx, today, g.config.LiveFeedInterval)
+
+	g.live.mu.Lock()
+	defer g.live.mu.Unlock()
+
+	if err != nil {
+		fmt.Println("Error polling the live feed:", err)
+		for client := range feed.clients {
+			client.push(LiveMessage{Type: "error", Date: today, Error: "Error making request to matches_ms for today's matches"})
+		}
+		return
+	}
+
+	var matches []Match
+	weather := make(map[string][]byte)
+	for _, city := range response.Weather {
+		matches = append(matches, city.Matches...)
+		// A failed lookup keeps the last known weather instead of being pushed as a change
+		if encoded, err := json.Marshal(city.Weather); err == nil && city.Error == nil {
+			weather[city.City] = encoded
+		} else if previous, ok := feed.weather[city.City]; ok {
+			weather[city.City] = previous
+		}
+	}
+
+	// The first poll of a day is a snapshot, later ones only push what changed
+	first := feed.response == nil || feed.date != today
+	matchesChanged := !first && !sameMatches(feed.matches, matches)
+	var changedCities []CityWeatherResponse
+	if !first {
+		for _, city := range response.Weather {
+			if encoded, ok := weather[city.City]; ok && !bytes.Equal(encoded, feed.weather[city.City]) {
+				changedCities = append(changedCities, city)
+			}
+		}
+	}
+
+	feed.date = today
+	feed.matches = matches
+	feed.weather = weather
+	feed.response = &response
+
+	for client := range feed.clients {
+		if first {
+			client.push(client.snapshot(feed))
+			continue
+		}
+
+		client.mu.Lock()
+		subscription := client.subscription
+		client.mu.Unlock()
+
+		if matchesChanged {
+			client.push(LiveMessage{Type: "matches", Date: today, Matches: subscribedMatches(subscription, matches)})
+		}
+		var cities []CityWeatherResponse
+		for _, city := range changedCities {
+			if subscription.selectsCity(city) {
+				cities = append(cities, city)
+			}
+		}
+		if len(cities) > 0 {
+			client.push(LiveMessage{Type: "weather", Date: today, Weather: cities})
+		}
+	}
+}
+
+// sameMatches reports whether two match lists are equal, including start times and venues
+func sameMatches(a, b []Match) bool {
+	encodedA, errA := json.Marshal(a)
+	encodedB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(encodedA, encodedB)
+}
+
+// subscribedMatches keeps the matches a subscription selects
+func subscribedMatches(subscription LiveSubscription, matches []Match) []Match {
+	selected := []Match{}
+	for _, match := range matches {
+		if subscription.selectsMatch(match) {
+			selected = append(selected, match)
+		}
+	}
+	return selected
+}
+
+// snapshot returns the feed's latest poll as the client's subscription sees it. Must be called with g.live.mu held.
+func (c *liveClient) snapshot(feed *liveFeed) LiveMessage {
+	c.mu.Lock()
+	subscription := c.subscription
+	c.mu.Unlock()
+
+	message := LiveMessage{Type: "snapshot", Date: feed.date, Weather: []CityWeatherResponse{}}
+	message.Matches = subscribedMatches(subscription, feed.matches)
+	for _, city := range feed.response.Weather {
+		if subscription.selectsCity(city) {
+			message.Weather = append(message.Weather, city)
+		}
+	}
+	return message
+}
+
+// push queues a message for the client. A client too slow to keep up is disconnected rather than
+// holding up the feed. Must be called with g.live.mu held, so send is not closed concurrently.
+func (c *liveClient) push(message LiveMessage) {
+	select {
+	case c.send <- message:
+	default:
+		c.conn.Close()
+	}
+}
+
+// writeMessages writes the queued messages to the connection until the client leaves its feed
+func (c *liveClient) writeMessages() {
+	defer c.conn.Close()
+	for message := range c.send {
+		c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if err := c.conn.WriteJSON(message); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"strings"
+)
+
+// locationAliases rewrites city names the weather API doesn't recognise, keyed by their lowercase normalised form
+var locationAliases = map[string]string{
+	"nyc":           "New York",
+	"new york city": "New York",
+	"la":            "Los Angeles",
+	"washington dc": "Washington",
+}
+
+// accentReplacer folds the accented letters found in North American and European city names
+var accentReplacer = strings.NewReplacer(
+	"á", "a", "à", "a", "â", "a", "ä", "a", "ã", "a", "å", "a",
+	"é", "e", "è", "e", "ê", "e", "ë", "e",
+	"í", "i", "ì", "i", "î", "i", "ï", "i",
+	"ó", "o", "ò", "o", "ô", "o", "ö", "o", "õ", "o",
+	"ú", "u", "ù", "u", "û", "u", "ü", "u",
+	"ç", "c", "ñ", "n", "ÿ", "y",
+	"Á", "A", "À", "A", "Â", "A", "Ä", "A", "Ã", "A", "Å", "A",
+	"É", "E", "È", "E", "Ê", "E", "Ë", "E",
+	"Í", "I", "Ì", "I", "Î", "I", "Ï", "I",
+	"Ó", "O", "Ò", "O", "Ô", "O", "Ö", "O", "Õ", "O",
+	"Ú", "U", "Ù", "U", "Û", "U", "Ü", "U",
+	"Ç", "C", "Ñ", "N",
+)
+
+// resolveLocation returns the canonical weather query for the place a match is played.
+// Venues in the registry are located by their coordinates, other matches by their city and region.
+// The weather microservice puts the query into its weather API URL as it is, so spaces become dashes.
+func (g *Gateway) resolveLocation(ctx context.Context, match Match) string {
+	if venue, ok := g.venues.Get(ctx, match.VenueFullName); ok {
+		return venue.query()
+	}
+
+	city := normalizeCity(match.City)
+	if venue, ok := g.venues.FindByCity(ctx, city); ok {
+		return venue.query()
+	}
+
+	region := normalizeCity(match.State)
+	if region == "" {
+		region = normalizeCity(match.Country)
+	}
+	if region == "" {
+		return locationQuery(city)
+	}
+	return locationQuery(city + "," + region)
+}
+
+// canonicalLocation turns a location passed by a client into the same query resolveLocation produces,
+// so single-location endpoints and aggregations share their cache entries
+func (g *Gateway) canonicalLocation(ctx context.Context, raw string) string {
+	if venue, ok := g.venues.Get(ctx, strings.TrimSpace(raw)); ok {
+		return venue.query()
+	}
+
+	// "City,Region" queries keep their region, but each part is normalised. Dashes are read as spaces,
+	// so "New-York" and "New York" are the same query.
+	parts := strings.Split(raw, ",")
+	for i, part := range parts {
+		parts[i] = normalizeCity(strings.ReplaceAll(part, "-", " "))
+	}
+	if len(parts) == 1 {
+		if venue, ok := g.venues.FindByCity(ctx, parts[0]); ok {
+			return venue.query()
+		}
+	}
+	return locationQuery(strings.Join(parts, ","))
+}
+
+// locationQuery makes a normalised location safe to put into a URL unescaped, as the baseline did with city names
+func locationQuery(location string) string {
+	return strings.ReplaceAll(location, " ", "-")
+}
+
+// normalizeCity folds accents, expands "St."/"Ste." abbreviations, collapses whitespace and applies the alias table
+func normalizeCity(name string) string {
+	name = accentReplacer.Replace(name)
+	name = strings.Join(strings.Fields(name), " ")
+
+	words := strings.Split(name, " ")
+	if len(words) > 1 {
+		switch strings.ToLower(strings.TrimSuffix(words[0], ".")) {
+		case "st":
+			words[0] = "Saint"
+		case "ste":
+			words[0] = "Sainte"
+		}
+	}
+	name = strings.Join(words, " ")
+
+	if alias, ok := locationAliases[strings.ToLower(strings.ReplaceAll(name, ".", ""))]; ok {
+		return alias
+	}
+	return name
+}
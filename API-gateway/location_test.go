@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// newTestGateway returns a gateway backed by the memory cache, with its clock pinned to now
+func newTestGateway(t *testing.T, now string) *Gateway {
+	t.Helper()
+	pinned, err := parseClockTime(now)
+	if err != nil {
+		t.Fatalf("parseClockTime(%q): %v", now, err)
+	}
+	clock := offsetClock{offset: time.Until(pinned)}
+	cache := newMemoryCache(clock)
+	cfg := loadConfig()
+	cfg.DefaultTimezone = "America/New_York"
+	return &Gateway{config: cfg, clock: clock, cache: cache, venues: newVenueRegistry(cache), live: newLiveFeeds()}
+}
+
+// withVenues replaces the built-in venues of a test gateway
+func withVenues(t *testing.T, g *Gateway, venues ...Venue) {
+	t.Helper()
+	ctx := context.Background()
+	if err := g.cache.Set(ctx, venueRegistryKey, "{}", 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, venue := range venues {
+		if _, err := g.venues.Put(ctx, venue); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCanonicalLocation(t *testing.T) {
+	g := newTestGateway(t, "2023-10-09T12:00:00Z")
+	withVenues(t, g, Venue{Name: "Arena", City: "Salt Lake City", Region: "Utah"})
+
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"Boston", "Boston"},
+		{"  Saint   Louis ,Missouri", "Saint-Louis,Missouri"},
+		{"St. Louis,Missouri", "Saint-Louis,Missouri"},
+		{"New York", "New-York"},
+		{"New-York", "New-York"},
+		{"nyc", "New-York"},
+		{"Montréal,Québec", "Montreal,Quebec"},
+		{"Salt Lake City", "Salt-Lake-City,Utah"},
+		{"Arena", "Salt-Lake-City,Utah"},
+	}
+	for _, tt := range tests {
+		if got := g.canonicalLocation(context.Background(), tt.raw); got != tt.want {
+			t.Errorf("canonicalLocation(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestResolveLocation(t *testing.T) {
+	g := newTestGateway(t, "2023-10-09T12:00:00Z")
+	withVenues(t, g, Venue{Name: "Coordinates Arena", City: "Las Vegas", Latitude: 36.1029, Longitude: -115.1784})
+
+	tests := []struct {
+		name  string
+		match Match
+		want  string
+	}{
+		{"multi-word city and state", Match{City: "St. Louis", State: "Missouri"}, "Saint-Louis,Missouri"},
+		{"multi-word city and country", Match{City: "New York City", Country: "USA"}, "New-York,USA"},
+		{"city only", Match{City: "Sainte Foy"}, "Sainte-Foy"},
+		{"registered venue", Match{City: "Anywhere", VenueFullName: "Coordinates Arena"}, "36.1029,-115.1784"},
+		{"venue found by city", Match{City: "Las Vegas", State: "Nevada"}, "36.1029,-115.1784"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := g.resolveLocation(context.Background(), tt.match); got != tt.want {
+				t.Errorf("resolveLocation() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
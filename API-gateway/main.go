@@ -5,11 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/afex/hystrix-go/hystrix"
-	"github.com/go-redis/redis/v8"
-	"io"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"net/http"
 	"net/url"
-	"strings"
 	"sync"
 	"time"
 )
@@ -32,15 +30,26 @@ type Match struct {
 	Country       string `json:"country"`
 	Date          string `json:"date"`
 	Name          string `json:"name"`
+	StartTime     string `json:"start_time"`
 	State         string `json:"state"`
 	UID           string `json:"uid"`
 	VenueFullName string `json:"venue_full_name"`
 }
 
 type WeatherForecastResponseWithInfo struct {
-	City     string                  `json:"city"`
-	UID      string                  `json:"uid"`
-	Forecast WeatherForecastResponse `json:"forecast"`
+	City      string                  `json:"city"`
+	UID       string                  `json:"uid"`
+	Source    string                  `json:"source"` // "forecast", or "climatology" beyond the forecast horizon
+	Forecast  WeatherForecastResponse `json:"forecast"`
+	AtFaceoff *FaceoffWeather         `json:"at_faceoff,omitempty"`
+	Match     *Match                  `json:"match,omitempty"`
+	Venue     *Venue                  `json:"venue,omitempty"`
+	Error     *ItemError              `json:"error,omitempty"`
+}
+
+type MatchesWeatherForecastResponse struct {
+	Partial   bool                              `json:"partial"`
+	Forecasts []WeatherForecastResponseWithInfo `json:"forecasts"`
 }
 
 type CurrentWeatherResponse struct {
@@ -53,12 +62,30 @@ type CurrentWeatherResponse struct {
 	WindMPH   float64 `json:"wind_mph"`
 }
 
+type CityWeatherResponse struct {
+	City    string                 `json:"city"`
+	Weather CurrentWeatherResponse `json:"weather"`
+	Matches []Match                `json:"matches,omitempty"`
+	Venue   *Venue                 `json:"venue,omitempty"`
+	Error   *ItemError             `json:"error,omitempty"`
+}
+
+// TodayMatchesWeatherResponse is the current weather in every city with matches today
+type TodayMatchesWeatherResponse struct {
+	Partial bool                  `json:"partial"`
+	Weather []CityWeatherResponse `json:"weather"`
+}
+
 type CombinedPastMatchResponse struct {
 	City          string                   `json:"city"`
 	UID           string                   `json:"uid"`
 	CityName      string                   `json:"city_name"`
 	Date          string                   `json:"date"`
 	HourlyWeather map[string]HourlyWeather `json:"hourly_weather"`
+	AtFaceoff     *FaceoffWeather          `json:"at_faceoff,omitempty"`
+	Match         *Match                   `json:"match,omitempty"`
+	Venue         *Venue                   `json:"venue,omitempty"`
+	Error         *ItemError               `json:"error,omitempty"`
 }
 
 type PastMatch struct {
@@ -66,6 +93,7 @@ type PastMatch struct {
 	Country       string `json:"country"`
 	Date          string `json:"date"`
 	Name          string `json:"name"`
+	StartTime     string `json:"start_time"`
 	State         string `json:"state"`
 	UID           string `json:"uid"`
 	VenueFullName string `json:"venue_full_name"`
@@ -111,23 +139,14 @@ func MatchesBalancer() string {
 	return endpointMatches
 }
 
-var redisClient *redis.Client
-
-func init() {
-	redisClient = redis.NewClient(&redis.Options{
-		Addr:     "redis-cache.pad:6379",
-		Password: "",
-		DB:       0,
-	})
-}
-
 // ForwardRequest forwards the incoming request to the Flask microservice
-func getWeatherRequest(w http.ResponseWriter, r *http.Request) {
+func (g *Gateway) getWeatherRequest(w http.ResponseWriter, r *http.Request) {
 	// Set the URL of the Flask microservice endpoint
 	//url := "http://weather-hostname.pad:5001/weather_forecast"
 
-	cacheKey := r.URL.Query().Get("location") + "_" + r.URL.Query().Get("date")
-	cachedResult, err := redisClient.Get(context.Background(), cacheKey).Result()
+	// Resolve the location to its canonical query, so aggregations reuse this cache entry
+	cacheKey := g.canonicalLocation(r.Context(), r.URL.Query().Get("location")) + "_" + r.URL.Query().Get("date")
+	cachedResult, err := g.cache.Get(r.Context(), cacheKey)
 	if err == nil {
 		// If cached result is found, return it
 		w.Write([]byte(cachedResult))
@@ -136,10 +155,10 @@ func getWeatherRequest(w http.ResponseWriter, r *http.Request) {
 
 	// Set the URL of the Flask microservice endpoint
 
-	url := RoundRobinBalancer() + "/weather_forecast"
+	endpoint := RoundRobinBalancer() + "/weather_forecast"
 
 	// Get query parameters from the incoming request
-	location := r.URL.Query().Get("location")
+	location := g.canonicalLocation(r.Context(), r.URL.Query().Get("location"))
 	date := r.URL.Query().Get("date")
 
 	// Validate parameters
@@ -148,18 +167,10 @@ func getWeatherRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create a new request to the Flask microservice
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		http.Error(w, "Error creating request", http.StatusInternalServerError)
-		return
-	}
-
 	// Set query parameters
-	q := req.URL.Query()
+	q := url.Values{}
 	q.Add("location", location)
 	q.Add("date", date)
-	req.URL.RawQuery = q.Encode()
 
 	// Wrap the HTTP request in a Hystrix command
 	hystrix.ConfigureCommand("getWeatherRequest", hystrix.CommandConfig{
@@ -168,46 +179,30 @@ func getWeatherRequest(w http.ResponseWriter, r *http.Request) {
 		ErrorPercentThreshold: 25,    // Error percentage threshold for circuit breaker
 	})
 
-	var resp *http.Response
-	var body []byte
-	err = hystrix.Do("getWeatherRequest", func() error {
-		// Make the request to the Flask microservice
-		client := http.Client{}
-		resp, err = client.Do(req)
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
-
-		// Read the response from the Flask microservice
-		body, err = io.ReadAll(resp.Body)
-		if err != nil {
-			return err
-		}
-
-		return nil
-	}, nil)
-
+	status, body, err := callUpstream(r.Context(), "getWeatherRequest", endpoint+"?"+q.Encode())
 	if err != nil {
 		// Handle the error, possibly returning an HTTP error response
 		http.Error(w, "Error making request to Flask microservice", http.StatusInternalServerError)
 		return
 	}
 
-	redisClient.Set(context.Background(), cacheKey, string(body), time.Hour)
+	g.cache.Set(context.Background(), cacheKey, string(body), time.Hour)
+	if status == http.StatusOK {
+		g.recordForecastVersion(r.Context(), location, date, body)
+	}
 
 	// Forward the response to the client
-	w.WriteHeader(resp.StatusCode)
+	w.WriteHeader(status)
 	w.Write(body)
 }
 
 // GetCurrentWeather connects to the Flask microservice's /current_weather endpoint
-func getCurrentWeather(w http.ResponseWriter, r *http.Request) {
+func (g *Gateway) getCurrentWeather(w http.ResponseWriter, r *http.Request) {
 	// Set the URL of the Flask microservice endpoint for current weather
 	//url := "http://weather-hostname.pad:5001/current_weather"
 
 	// Get query parameters from the incoming request
-	city := r.URL.Query().Get("city")
+	city := g.canonicalLocation(r.Context(), r.URL.Query().Get("city"))
 
 	// Validate parameters
 	if city == "" {
@@ -215,31 +210,26 @@ func getCurrentWeather(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Determine the current date
-	today := time.Now().Format("2006-01-02") // Format: YYYY-MM-DD
+	// Determine the current date in the requested timezone
+	today, ok := g.requestToday(w, r)
+	if !ok {
+		return
+	}
 
 	cacheKey := "current_weather_" + city + "_" + today
 
 	// Check if the result is already in the cache
-	cachedResult, err := redisClient.Get(context.Background(), cacheKey).Result()
+	cachedResult, err := g.cache.Get(r.Context(), cacheKey)
 	if err == nil {
 		// If cached result is found, return it
 		w.Write([]byte(cachedResult))
 		return
 	}
 
-	url := RoundRobinBalancer() + "/current_weather"
-	// Create a new request to the Flask microservice for current weather
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		http.Error(w, "Error creating request for current weather", http.StatusInternalServerError)
-		return
-	}
-
+	endpoint := RoundRobinBalancer() + "/current_weather"
 	// Set query parameters for current weather
-	q := req.URL.Query()
+	q := url.Values{}
 	q.Add("city", city)
-	req.URL.RawQuery = q.Encode()
 
 	// Wrap the HTTP request in a Hystrix command
 	hystrix.ConfigureCommand("getCurrentWeather", hystrix.CommandConfig{
@@ -248,46 +238,27 @@ func getCurrentWeather(w http.ResponseWriter, r *http.Request) {
 		ErrorPercentThreshold: 25,    // Error percentage threshold for circuit breaker
 	})
 
-	var resp *http.Response
-	var body []byte
-	err = hystrix.Do("getCurrentWeather", func() error {
-		// Make the request to the Flask microservice for current weather
-		client := http.Client{}
-		resp, err = client.Do(req)
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
-
-		// Read the response from the Flask microservice for current weather
-		body, err = io.ReadAll(resp.Body)
-		if err != nil {
-			return err
-		}
-
-		return nil
-	}, nil)
-
+	status, body, err := callUpstream(r.Context(), "getCurrentWeather", endpoint+"?"+q.Encode())
 	if err != nil {
 		// Handle the error, possibly returning an HTTP error response
 		http.Error(w, "Error making request to Flask microservice for current weather", http.StatusInternalServerError)
 		return
 	}
 
-	redisClient.Set(context.Background(), cacheKey, string(body), time.Hour)
+	g.cache.Set(context.Background(), cacheKey, string(body), time.Hour)
 
 	// Forward the response to the client for current weather
-	w.WriteHeader(resp.StatusCode)
+	w.WriteHeader(status)
 	w.Write(body)
 }
 
 // GetWeatherHistory connects to the Flask microservice's /weather_history endpoint
-func getWeatherHistory(w http.ResponseWriter, r *http.Request) {
+func (g *Gateway) getWeatherHistory(w http.ResponseWriter, r *http.Request) {
 	// Set the URL of the Flask microservice endpoint for weather history
 	//url := "http://weather-hostname.pad:5001/weather_history"
 
 	// Get query parameters from the incoming request
-	location := r.URL.Query().Get("location")
+	location := g.canonicalLocation(r.Context(), r.URL.Query().Get("location"))
 	date := r.URL.Query().Get("date")
 
 	// Validate parameters
@@ -299,27 +270,19 @@ func getWeatherHistory(w http.ResponseWriter, r *http.Request) {
 	// Create a cache key based on location and date
 	cacheKey := "weather_history_" + location + "_" + date
 	// Check if the result is already in the cache
-	cachedResult, err := redisClient.Get(context.Background(), cacheKey).Result()
+	cachedResult, err := g.cache.Get(r.Context(), cacheKey)
 	if err == nil {
 		// If cached result is found, return it
 		w.Write([]byte(cachedResult))
 		return
 	}
 
-	url := RoundRobinBalancer() + "/weather_history"
-
-	// Create a new request to the Flask microservice for weather history
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		http.Error(w, "Error creating request for weather history", http.StatusInternalServerError)
-		return
-	}
+	endpoint := RoundRobinBalancer() + "/weather_history"
 
 	// Set query parameters for weather history
-	q := req.URL.Query()
+	q := url.Values{}
 	q.Add("location", location)
 	q.Add("date", date)
-	req.URL.RawQuery = q.Encode()
 
 	// Wrap the HTTP request in a Hystrix command
 	hystrix.ConfigureCommand("getWeatherHistory", hystrix.CommandConfig{
@@ -328,26 +291,7 @@ func getWeatherHistory(w http.ResponseWriter, r *http.Request) {
 		ErrorPercentThreshold: 25,    // Error percentage threshold for circuit breaker
 	})
 
-	var resp *http.Response
-	var body []byte
-	err = hystrix.Do("getWeatherHistory", func() error {
-		// Make the request to the Flask microservice for weather history
-		client := http.Client{}
-		resp, err = client.Do(req)
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
-
-		// Read the response from the Flask microservice for weather history
-		body, err = io.ReadAll(resp.Body)
-		if err != nil {
-			return err
-		}
-
-		return nil
-	}, nil)
-
+	status, body, err := callUpstream(r.Context(), "getWeatherHistory", endpoint+"?"+q.Encode())
 	if err != nil {
 		// Handle the error, possibly returning an HTTP error response
 		http.Error(w, "Error making request to Flask microservice for weather history", http.StatusInternalServerError)
@@ -355,20 +299,20 @@ func getWeatherHistory(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Cache the result in Redis with an expiration time
-	redisClient.Set(context.Background(), cacheKey, string(body), time.Hour)
+	g.cache.Set(context.Background(), cacheKey, string(body), time.Hour)
 
 	// Forward the response to the client for weather history
-	w.WriteHeader(resp.StatusCode)
+	w.WriteHeader(status)
 	w.Write(body)
 }
 
 // GetAstroInfo connects to the Flask microservice's /astro endpoint
-func getAstroInfo(w http.ResponseWriter, r *http.Request) {
+func (g *Gateway) getAstroInfo(w http.ResponseWriter, r *http.Request) {
 	// Set the URL of the Flask microservice endpoint for astro information
 	//url := "http://weather-hostname.pad:5001/astro"
 
 	// Get query parameters from the incoming request
-	city := r.URL.Query().Get("city")
+	city := g.canonicalLocation(r.Context(), r.URL.Query().Get("city"))
 	date := r.URL.Query().Get("date")
 
 	// Validate parameters
@@ -381,27 +325,19 @@ func getAstroInfo(w http.ResponseWriter, r *http.Request) {
 	cacheKey := "astro_info_" + city + "_" + date
 
 	// Check if the result is already in the cache
-	cachedResult, err := redisClient.Get(context.Background(), cacheKey).Result()
+	cachedResult, err := g.cache.Get(r.Context(), cacheKey)
 	if err == nil {
 		// If cached result is found, return it
 		w.Write([]byte(cachedResult))
 		return
 	}
 
-	url := RoundRobinBalancer() + "/astro"
-
-	// Create a new request to the Flask microservice for astro information
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		http.Error(w, "Error creating request for astro information", http.StatusInternalServerError)
-		return
-	}
+	endpoint := RoundRobinBalancer() + "/astro"
 
 	// Set query parameters for astro information
-	q := req.URL.Query()
+	q := url.Values{}
 	q.Add("city", city)
 	q.Add("date", date)
-	req.URL.RawQuery = q.Encode()
 
 	// Wrap the HTTP request in a Hystrix command
 	hystrix.ConfigureCommand("getAstroInfo", hystrix.CommandConfig{
@@ -410,26 +346,7 @@ func getAstroInfo(w http.ResponseWriter, r *http.Request) {
 		ErrorPercentThreshold: 25,   // Error percentage threshold for circuit breaker
 	})
 
-	var resp *http.Response
-	var body []byte
-	err = hystrix.Do("getAstroInfo", func() error {
-		// Make the request to the Flask microservice for astro information
-		client := http.Client{}
-		resp, err = client.Do(req)
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
-
-		// Read the response from the Flask microservice for astro information
-		body, err = io.ReadAll(resp.Body)
-		if err != nil {
-			return err
-		}
-
-		return nil
-	}, nil)
-
+	status, body, err := callUpstream(r.Context(), "getAstroInfo", endpoint+"?"+q.Encode())
 	if err != nil {
 		// Handle the error, possibly returning an HTTP error response
 		http.Error(w, "Error making request to Flask microservice for astro information", http.StatusInternalServerError)
@@ -437,28 +354,31 @@ func getAstroInfo(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Cache the result in Redis with an expiration time
-	redisClient.Set(context.Background(), cacheKey, string(body), time.Hour)
+	g.cache.Set(context.Background(), cacheKey, string(body), time.Hour)
 
 	// Forward the response to the client for astro information
-	w.WriteHeader(resp.StatusCode)
+	w.WriteHeader(status)
 	w.Write(body)
 }
 
 // GetUpcomingMatches connects to the matches microservice's /upcoming_matches endpoint
-func getUpcomingMatches(w http.ResponseWriter, r *http.Request) {
-	// Determine today's date
-	today := time.Now().Format("2006-01-02") // Format: YYYY-MM-DD
+func (g *Gateway) getUpcomingMatches(w http.ResponseWriter, r *http.Request) {
+	// Determine today's date in the requested timezone
+	today, ok := g.requestToday(w, r)
+	if !ok {
+		return
+	}
 	// Create a cache key based on today's date
 	cacheKey := "upcoming_matches_" + today
 	// Check if the result is already in the cache
-	cachedResult, err := redisClient.Get(context.Background(), cacheKey).Result()
+	cachedResult, err := g.cache.Get(r.Context(), cacheKey)
 	if err == nil {
 		// If cached result is found, return it
 		w.Write([]byte(cachedResult))
 		return
 	}
 
-	url := MatchesBalancer() + "/upcoming_matches"
+	endpoint := MatchesBalancer() + "/upcoming_matches?" + url.Values{"date": {today}}.Encode()
 
 	// Wrap the HTTP request in a Hystrix command
 	hystrix.ConfigureCommand("getUpcomingMatches", hystrix.CommandConfig{
@@ -467,59 +387,44 @@ func getUpcomingMatches(w http.ResponseWriter, r *http.Request) {
 		ErrorPercentThreshold: 25,    // Error percentage threshold for circuit breaker
 	})
 
-	var resp *http.Response
-	var body []byte
-	err = hystrix.Do("getUpcomingMatches", func() error {
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			return err
-		}
-		// Make the request to the matches microservice for today's matches
-		client := http.Client{}
-		resp, err = client.Do(req)
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
-
-		// Read the response from the matches microservice for upcoming matches
-		body, err = io.ReadAll(resp.Body)
-		if err != nil {
-			return err
-		}
-
-		return nil
-	}, nil)
-
+	status, body, err := callUpstream(r.Context(), "getUpcomingMatches", endpoint)
 	if err != nil {
 		// Handle the error, possibly returning an HTTP error response
 		http.Error(w, "Error making request to matches microservice for upcoming matches", http.StatusInternalServerError)
 		return
 	}
-	redisClient.Set(context.Background(), cacheKey, string(body), time.Hour)
+	// The schedule is compared first, as a change invalidates the cached lists of today
+	var matches []Match
+	if status == http.StatusOK && json.Unmarshal(body, &matches) == nil {
+		g.recordSchedule(r.Context(), today, matches)
+	}
+	g.cache.Set(context.Background(), cacheKey, string(body), time.Hour)
 
 	// Forward the response to the client
-	w.WriteHeader(resp.StatusCode)
+	w.WriteHeader(status)
 	w.Write(body)
 }
 
 // GetTodayMatches connects to the matches microservice's /today_matches endpoint
-func getTodayMatches(w http.ResponseWriter, r *http.Request) {
-	// Determine today's date
-	today := time.Now().Format("2006-01-02") // Format: YYYY-MM-DD
+func (g *Gateway) getTodayMatches(w http.ResponseWriter, r *http.Request) {
+	// Determine today's date in the requested timezone
+	today, ok := g.requestToday(w, r)
+	if !ok {
+		return
+	}
 
 	// Create a cache key based on today's date
 	cacheKey := "today_matches_" + today
 
 	// Check if the result is already in the cache
-	cachedResult, err := redisClient.Get(context.Background(), cacheKey).Result()
+	cachedResult, err := g.cache.Get(r.Context(), cacheKey)
 	if err == nil {
 		// If cached result is found, return it
 		w.Write([]byte(cachedResult))
 		return
 	}
 
-	url := MatchesBalancer() + "/today_matches"
+	endpoint := MatchesBalancer() + "/today_matches?" + url.Values{"date": {today}}.Encode()
 
 	// Wrap the HTTP request in a Hystrix command
 	hystrix.ConfigureCommand("getTodayMatches", hystrix.CommandConfig{
@@ -528,30 +433,7 @@ func getTodayMatches(w http.ResponseWriter, r *http.Request) {
 		ErrorPercentThreshold: 25,   // Error percentage threshold for circuit breaker
 	})
 
-	var resp *http.Response
-	var body []byte
-	err = hystrix.Do("getTodayMatches", func() error {
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			return err
-		}
-		// Make the request to the matches microservice for today's matches
-		client := http.Client{}
-		resp, err = client.Do(req)
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
-
-		// Read the response from the matches microservice for today's matches
-		body, err = io.ReadAll(resp.Body)
-		if err != nil {
-			return err
-		}
-
-		return nil
-	}, nil)
-
+	status, body, err := callUpstream(r.Context(), "getTodayMatches", endpoint)
 	if err != nil {
 		// Handle the error, possibly returning an HTTP error response
 		http.Error(w, "Error making request to matches microservice for today's matches", http.StatusInternalServerError)
@@ -559,15 +441,15 @@ func getTodayMatches(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Cache the result in Redis with an expiration time
-	redisClient.Set(context.Background(), cacheKey, string(body), time.Hour)
+	g.cache.Set(context.Background(), cacheKey, string(body), time.Hour)
 
 	// Forward the response to the client
-	w.WriteHeader(resp.StatusCode)
+	w.WriteHeader(status)
 	w.Write(body)
 }
 
 // GetPastMatches connects to the matches microservice's /past_matches endpoint
-func getPastMatches(w http.ResponseWriter, r *http.Request) {
+func (g *Gateway) getPastMatches(w http.ResponseWriter, r *http.Request) {
 
 	// Get query parameters from the incoming request
 	targetDate := r.URL.Query().Get("target_date")
@@ -581,14 +463,14 @@ func getPastMatches(w http.ResponseWriter, r *http.Request) {
 	// Create a cache key based on the target date
 	cacheKey := "past_matches_" + targetDate
 	// Check if the result is already in the cache
-	cachedResult, err := redisClient.Get(context.Background(), cacheKey).Result()
+	cachedResult, err := g.cache.Get(r.Context(), cacheKey)
 	if err == nil {
 		// If cached result is found, return it
 		w.Write([]byte(cachedResult))
 		return
 	}
 
-	url := MatchesBalancer() + "/past_matches"
+	endpoint := MatchesBalancer() + "/past_matches"
 
 	// Wrap the HTTP request in a Hystrix command
 	hystrix.ConfigureCommand("getPastMatches", hystrix.CommandConfig{
@@ -597,52 +479,26 @@ func getPastMatches(w http.ResponseWriter, r *http.Request) {
 		ErrorPercentThreshold: 25,    // Error percentage threshold for circuit breaker
 	})
 
-	var resp *http.Response
-	var body []byte
-	err = hystrix.Do("getPastMatches", func() error {
-		// Create a new request to the matches microservice for past matches
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			return err
-		}
-
-		// Set query parameters for past matches
-		q := req.URL.Query()
-		q.Add("target_date", targetDate)
-		req.URL.RawQuery = q.Encode()
-
-		// Make the request to the matches microservice for past matches
-		client := http.Client{}
-		resp, err = client.Do(req)
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
-
-		// Read the response from the matches microservice for past matches
-		body, err = io.ReadAll(resp.Body)
-		if err != nil {
-			return err
-		}
-
-		return nil
-	}, nil)
+	// Set query parameters for past matches
+	q := url.Values{}
+	q.Add("target_date", targetDate)
 
+	status, body, err := callUpstream(r.Context(), "getPastMatches", endpoint+"?"+q.Encode())
 	if err != nil {
 		// Handle the error, possibly returning an HTTP error response
 		http.Error(w, "Error making request to matches microservice for past matches", http.StatusInternalServerError)
 		return
 	}
 
-	redisClient.Set(context.Background(), cacheKey, string(body), time.Hour)
+	g.cache.Set(context.Background(), cacheKey, string(body), time.Hour)
 
 	// Forward the response to the client for past matches
-	w.WriteHeader(resp.StatusCode)
+	w.WriteHeader(status)
 	w.Write(body)
 }
 
 // GetTeamInfo connects to the matches microservice's /team_info endpoint
-func getTeamInfo(w http.ResponseWriter, r *http.Request) {
+func (g *Gateway) getTeamInfo(w http.ResponseWriter, r *http.Request) {
 
 	// Get query parameters from the incoming request
 	gameID := r.URL.Query().Get("game_id")
@@ -657,14 +513,14 @@ func getTeamInfo(w http.ResponseWriter, r *http.Request) {
 	cacheKey := "team_info_" + gameID
 
 	// Check if the result is already in the cache
-	cachedResult, err := redisClient.Get(context.Background(), cacheKey).Result()
+	cachedResult, err := g.cache.Get(r.Context(), cacheKey)
 	if err == nil {
 		// If cached result is found, return it
 		w.Write([]byte(cachedResult))
 		return
 	}
 
-	url := MatchesBalancer() + "/team_info"
+	endpoint := MatchesBalancer() + "/team_info"
 
 	// Wrap the HTTP request in a Hystrix command
 	hystrix.ConfigureCommand("getTeamInfo", hystrix.CommandConfig{
@@ -673,50 +529,24 @@ func getTeamInfo(w http.ResponseWriter, r *http.Request) {
 		ErrorPercentThreshold: 25,   // Error percentage threshold for circuit breaker
 	})
 
-	var resp *http.Response
-	var body []byte
-	err = hystrix.Do("getTeamInfo", func() error {
-		// Create a new request to the matches microservice for team info
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			return err
-		}
-
-		// Set query parameters for team info
-		q := req.URL.Query()
-		q.Add("game_id", gameID)
-		req.URL.RawQuery = q.Encode()
-
-		// Make the request to the matches microservice for team info
-		client := http.Client{}
-		resp, err = client.Do(req)
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
-
-		// Read the response from the matches microservice for team info
-		body, err = io.ReadAll(resp.Body)
-		if err != nil {
-			return err
-		}
-
-		return nil
-	}, nil)
+	// Set query parameters for team info
+	q := url.Values{}
+	q.Add("game_id", gameID)
 
+	status, body, err := callUpstream(r.Context(), "getTeamInfo", endpoint+"?"+q.Encode())
 	if err != nil {
 		// Handle the error, possibly returning an HTTP error response
 		http.Error(w, "Error making request to matches microservice for team info", http.StatusInternalServerError)
 		return
 	}
-	redisClient.Set(context.Background(), cacheKey, string(body), time.Hour)
+	g.cache.Set(context.Background(), cacheKey, string(body), time.Hour)
 
 	// Forward the response to the client for team info
-	w.WriteHeader(resp.StatusCode)
+	w.WriteHeader(status)
 	w.Write(body)
 }
 
-func getMatchesWeatherForecast(w http.ResponseWriter, r *http.Request) {
+func (g *Gateway) getMatchesWeatherForecast(w http.ResponseWriter, r *http.Request) {
 	// Configure Hystrix settings for "getMatches" command
 	hystrix.ConfigureCommand("getMatches", hystrix.CommandConfig{
 		Timeout:               20000, // Timeout in milliseconds
@@ -724,245 +554,360 @@ func getMatchesWeatherForecast(w http.ResponseWriter, r *http.Request) {
 		ErrorPercentThreshold: 25,    // Error percentage threshold
 	})
 
-	// Configure Hystrix settings for "getWeather" command
-	hystrix.ConfigureCommand("getWeather", hystrix.CommandConfig{
-		Timeout:               10000, // Timeout in milliseconds
-		MaxConcurrentRequests: 10,    // Maximum number of concurrent requests
-		ErrorPercentThreshold: 25,    // Error percentage threshold
-	})
-
-	// Determine today's date
-	today := time.Now().Format("2006-01-02") // Format: YYYY-MM-DD
+	// Determine today's date in the requested timezone
+	today, ok := g.requestToday(w, r)
+	if !ok {
+		return
+	}
 
 	// Create a cache key based on today's date
 	cacheKey := "matches_weather_forecast_" + today
 
+	// The full-day view keeps all hourly entries instead of only the faceoff window
+	fullDay := r.URL.Query().Get("full_day") == "true"
+	if fullDay {
+		cacheKey += "_full_day"
+	}
+
+	// Forecasts are streamed one by one when the client asks for NDJSON or server-sent events
+	stream := newAggregationStream(w, r)
+
 	// Check if the result is already in the cache
-	cachedResult, err := redisClient.Get(context.Background(), cacheKey).Result()
+	cachedResult, err := g.cache.Get(r.Context(), cacheKey)
 	if err == nil {
-		// If cached result is found, return it
-		w.Write([]byte(cachedResult))
-		return
+		var cached MatchesWeatherForecastResponse
+		if stream == nil {
+			// If cached result is found, return it
+			w.Write([]byte(cachedResult))
+			return
+		} else if json.Unmarshal([]byte(cachedResult), &cached) == nil {
+			for _, forecast := range cached.Forecasts {
+				stream.item(forecast.UID, forecast, forecast.Error)
+			}
+			stream.finish()
+			return
+		}
 	}
 
 	// Step 1: Get upcoming matches
-	matchesURL := MatchesBalancer() + "/upcoming_matches"
-	var matchesResp *http.Response
-	err = hystrix.Do("getMatches", func() error {
-		resp, err := http.Get(matchesURL)
-		if err != nil {
-			return err
-		}
-		matchesResp = resp
-		return nil
-	}, nil)
+	matchesURL := MatchesBalancer() + "/upcoming_matches?" + url.Values{"date": {today}}.Encode()
+	_, matchesBody, err := callUpstream(r.Context(), "getMatches", matchesURL)
 	if err != nil {
 		http.Error(w, "Error making request to matches_ms for upcoming matches", http.StatusInternalServerError)
 		return
 	}
-	defer matchesResp.Body.Close()
-
-	matchesBody, err := io.ReadAll(matchesResp.Body)
-	if err != nil {
-		http.Error(w, "Error reading response from matches_ms for upcoming matches", http.StatusInternalServerError)
-		return
-	}
 	// Parse the matches response
 	var matches []Match // Replace Match with the actual struct type for your matches
 	if err := json.Unmarshal(matchesBody, &matches); err != nil {
 		http.Error(w, "Error parsing upcoming matches response", http.StatusInternalServerError)
 		return
 	}
+	g.recordSchedule(r.Context(), today, matches)
 
-	// Step 2: Get weather forecast for each location
-	var forecasts []WeatherForecastResponseWithInfo
+	// Step 2: Get weather forecast for each location, several matches at a time
+	var forecastMatches []Match
 	for _, match := range matches {
 		// Skip if city is empty
 		if match.City == "" {
 			continue
 		}
+		forecastMatches = append(forecastMatches, match)
+	}
+	response := MatchesWeatherForecastResponse{Forecasts: make([]WeatherForecastResponseWithInfo, len(forecastMatches))}
+	g.streamMatchForecasts(r.Context(), forecastMatches, fullDay, func(i int, forecast WeatherForecastResponseWithInfo) {
+		response.Forecasts[i] = forecast
+		if forecast.Error != nil {
+			response.Partial = true
+		}
+		if stream != nil {
+			stream.item(forecast.UID, forecast, forecast.Error)
+		}
+	})
 
-		// Replace spaces with "&" for multi-word cities
-		cityQuery := strings.ReplaceAll(match.City, " ", "-")
+	// Step 3: Return the combined forecast to the user
+	var responseBody []byte
+	if stream != nil {
+		stream.finish()
+		responseBody, err = json.Marshal(response)
+	} else {
+		responseBody, err = writeAggregation(w, response, response.Partial)
+	}
+	if err != nil {
+		http.Error(w, "Error encoding matches weather forecast response", http.StatusInternalServerError)
+		return
+	}
+
+	// Cache the result in Redis with an expiration time, unless some matches are missing
+	if !response.Partial {
+		g.cache.Set(context.Background(), cacheKey, string(responseBody), time.Hour)
+	}
 
-		weatherURL := RoundRobinBalancer() + "/weather_forecast?location=" + cityQuery + "&date=" + match.Date
-		var weatherResp *http.Response
-		err := hystrix.Do("getWeather", func() error {
-			resp, err := http.Get(weatherURL)
-			if err != nil {
-				return err
+}
+
+// matchForecasts looks up the forecast at the venue of each match, aligned with its faceoff.
+// Failed lookups are reported on the item, so one missing city doesn't fail the others.
+func (g *Gateway) matchForecasts(ctx context.Context, matches []Match, fullDay bool) []WeatherForecastResponseWithInfo {
+	forecasts := make([]WeatherForecastResponseWithInfo, len(matches))
+	g.streamMatchForecasts(ctx, matches, fullDay, func(i int, forecast WeatherForecastResponseWithInfo) {
+		forecasts[i] = forecast
+	})
+	return forecasts
+}
+
+// streamMatchForecasts works like matchForecasts but hands each match's forecast to emit as soon as it is known.
+// emit runs on the calling goroutine.
+func (g *Gateway) streamMatchForecasts(ctx context.Context, matches []Match, fullDay bool, emit func(i int, forecast WeatherForecastResponseWithInfo)) {
+	// Configure Hystrix settings for "getWeather" command
+	hystrix.ConfigureCommand("getWeather", hystrix.CommandConfig{
+		Timeout:               10000,                        // Timeout in milliseconds
+		MaxConcurrentRequests: weatherCommandMaxConcurrency, // Maximum number of concurrent requests
+		ErrorPercentThreshold: 25,                           // Error percentage threshold
+	})
+
+	// Matches in the same city on the same local day share one lookup, keyed like getWeatherRequest's cache entries.
+	// Matches beyond the forecast horizon share a climatological estimate instead.
+	var lookups weatherLookups
+	lookupMatches := make(map[int][]int)
+	var estimates []string
+	estimateMatches := make(map[string][]int)
+	locations := make([]string, len(matches))
+	faceoffs := make([]faceoff, len(matches))
+	for i, match := range matches {
+		locations[i] = g.resolveLocation(ctx, match)
+		faceoffs[i] = g.matchFaceoff(ctx, match)
+
+		if g.beyondForecastHorizon(ctx, faceoffs[i]) {
+			key := locations[i] + "_" + faceoffs[i].date()
+			if _, ok := estimateMatches[key]; !ok {
+				estimates = append(estimates, key)
 			}
-			weatherResp = resp
-			return nil
-		}, nil)
-		if err != nil {
-			http.Error(w, "Error making request to weather microservice", http.StatusInternalServerError)
-			return
+			estimateMatches[key] = append(estimateMatches[key], i)
+			continue
 		}
-		defer weatherResp.Body.Close()
 
-		weatherBody, err := io.ReadAll(weatherResp.Body)
-		if err != nil {
-			http.Error(w, "Error reading response from weather microservice", http.StatusInternalServerError)
-			return
-		}
+		lookup := lookups.add(locations[i]+"_"+faceoffs[i].date(),
+			"/weather_forecast?"+url.Values{"location": {locations[i]}, "date": {faceoffs[i].date()}}.Encode())
+		lookupMatches[lookup] = append(lookupMatches[lookup], i)
+	}
+	lookups.fetched = func(lookup int, body []byte) {
+		first := lookupMatches[lookup][0]
+		g.recordForecastVersion(ctx, locations[first], faceoffs[first].date(), body)
+	}
 
-		// Parse the weather response
-		var forecast WeatherForecastResponse
-		if err := json.Unmarshal(weatherBody, &forecast); err != nil {
-			fmt.Printf("Error parsing weather forecast response: %v\n", err)
-			fmt.Printf("Response body: %s\n", string(weatherBody))
-			http.Error(w, "Error parsing weather forecast response", http.StatusInternalServerError)
-			return
+	emitForecast := func(i int, forecast WeatherForecastResponse, source string, err error) {
+		window, atFaceoff := g.faceoffWindow(faceoffs[i], forecast.HourlyWeather)
+		if !fullDay {
+			forecast.HourlyWeather = window
+		}
+		if err == nil && source == sourceForecast {
+			g.recordForecastSnapshot(ctx, matches[i], locations[i], faceoffs[i], atFaceoff)
 		}
 
-		forecasts = append(forecasts, WeatherForecastResponseWithInfo{
-			City:     match.City,
-			UID:      match.UID,
-			Forecast: forecast,
+		emit(i, WeatherForecastResponseWithInfo{
+			City:      matches[i].City,
+			UID:       matches[i].UID,
+			Source:    source,
+			Forecast:  forecast,
+			AtFaceoff: atFaceoff,
+			Match:     &matches[i],
+			Venue:     g.matchVenue(ctx, matches[i]),
+			Error:     newItemError("Error making request to weather microservice", err),
 		})
 	}
 
-	// Step 3: Return the combined forecast to the user
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(forecasts)
-
-	// Cache the result in Redis with an expiration time
-	redisClient.Set(context.Background(), cacheKey, string(matchesBody), time.Hour)
+	g.streamWeatherLookups(ctx, "getWeather", &lookups, func(lookup int, body []byte, err error) {
+		for _, i := range lookupMatches[lookup] {
+			var forecast WeatherForecastResponse
+			err := err
+			if err == nil {
+				err = decodeWeather(body, &forecast)
+			}
+			emitForecast(i, forecast, sourceForecast, err)
+		}
+	})
 
+	estimateLocations := make([]string, len(estimates))
+	estimateDates := make([]string, len(estimates))
+	for e, key := range estimates {
+		first := estimateMatches[key][0]
+		estimateLocations[e], estimateDates[e] = locations[first], faceoffs[first].date()
+	}
+	forecasts, errs := g.climatologies(ctx, estimateLocations, estimateDates)
+	for e, key := range estimates {
+		for _, i := range estimateMatches[key] {
+			emitForecast(i, forecasts[e], sourceClimatology, errs[e])
+		}
+	}
 }
 
-func getTodayMatchesAndWeather(w http.ResponseWriter, r *http.Request) {
-	// Configure Hystrix for the "get-today-matches" command
-	hystrix.ConfigureCommand("get-today-matches", hystrix.CommandConfig{
-		Timeout:               10000, // Timeout in milliseconds
-		MaxConcurrentRequests: 10,    // Max concurrent requests
-		ErrorPercentThreshold: 25,    // Error percentage threshold for circuit breaker
-	})
-
-	// Step 1: Determine today's date
-	today := time.Now().Format("2006-01-02") // Format: YYYY-MM-DD
+func (g *Gateway) getTodayMatchesAndWeather(w http.ResponseWriter, r *http.Request) {
+	// Step 1: Determine today's date in the requested timezone
+	today, ok := g.requestToday(w, r)
+	if !ok {
+		return
+	}
 
 	// Create a cache key based on today's date
 	cacheKey := "today_matches_and_weather_" + today
 
+	// Cities are streamed one by one when the client asks for NDJSON or server-sent events
+	stream := newAggregationStream(w, r)
+
 	// Check if the result is already in the cache
-	cachedResult, err := redisClient.Get(context.Background(), cacheKey).Result()
+	cachedResult, err := g.cache.Get(r.Context(), cacheKey)
 	if err == nil {
-		// If cached result is found, return it
-		w.Write([]byte(cachedResult))
-		return
+		var cached TodayMatchesWeatherResponse
+		if stream == nil {
+			// If cached result is found, return it
+			w.Write([]byte(cachedResult))
+			return
+		} else if json.Unmarshal([]byte(cachedResult), &cached) == nil {
+			for _, city := range cached.Weather {
+				stream.item(city.City, city, city.Error)
+			}
+			stream.finish()
+			return
+		}
 	}
 
-	// Step 1: Get today's matches using Hystrix
-	var matchesResp *http.Response
-	err = hystrix.Do("get-today-matches", func() error {
-		var err error
-		matchesURL := MatchesBalancer() + "/today_matches"
-		matchesResp, err = http.Get(matchesURL)
-		return err
-	}, nil)
-
+	// Today's matches are fetched before any city is sent, so a failure can still be a regular error response
+	var response TodayMatchesWeatherResponse
+	if stream != nil {
+		response, err = g.streamTodayMatchesWeather(r.Context(), today, 0, func(i int, city CityWeatherResponse) {
+			stream.item(city.City, city, city.Error)
+		})
+	} else {
+		response, err = g.todayMatchesWeather(r.Context(), today, 0)
+	}
 	if err != nil {
 		http.Error(w, "Error making request to matches_ms for today's matches", http.StatusInternalServerError)
 		return
 	}
-	defer matchesResp.Body.Close()
 
-	matchesBody, err := io.ReadAll(matchesResp.Body)
-	if err != nil {
-		http.Error(w, "Error reading response from matches_ms for today's matches", http.StatusInternalServerError)
-		return
+	var responseBody []byte
+	if stream != nil {
+		stream.finish()
+		responseBody, err = json.Marshal(response)
+	} else {
+		responseBody, err = writeAggregation(w, response, response.Partial)
 	}
-
-	// Parse the matches response
-	var matches []Match // Replace Match with the actual struct type for your matches
-	if err := json.Unmarshal(matchesBody, &matches); err != nil {
-		http.Error(w, "Error parsing today's matches response", http.StatusInternalServerError)
+	if err != nil {
+		http.Error(w, "Error encoding today's matches weather response", http.StatusInternalServerError)
 		return
 	}
 
-	// Step 2: Find unique cities where matches are held
-	citiesMap := make(map[string]bool)
-	for _, match := range matches {
-		citiesMap[match.City] = true
+	// Cache the result in Redis with an expiration time, unless some cities are missing
+	if !response.Partial {
+		g.cache.Set(context.Background(), cacheKey, string(responseBody), time.Hour)
 	}
+}
 
-	// Step 3: Get current weather for each city using Hystrix
-	var weatherResponses []struct {
-		City    string                 `json:"city"`
-		Weather CurrentWeatherResponse `json:"weather"`
-	}
+// todayMatchesWeather composes today's matches with the current weather in each of their cities.
+// With a maxAge, the matches and the current weather are read from cache entries of their own that expire
+// after maxAge, instead of fetching the matches every time and reusing current weather up to an hour old.
+func (g *Gateway) todayMatchesWeather(ctx context.Context, today string, maxAge time.Duration) (TodayMatchesWeatherResponse, error) {
+	return g.streamTodayMatchesWeather(ctx, today, maxAge, func(int, CityWeatherResponse) {})
+}
 
-	// Configure Hystrix for the "get-current-weather" command
-	hystrix.ConfigureCommand("get-current-weather", hystrix.CommandConfig{
+// streamTodayMatchesWeather works like todayMatchesWeather but also hands each city to emit as soon as its
+// weather is known. emit runs on the calling goroutine.
+func (g *Gateway) streamTodayMatchesWeather(ctx context.Context, today string, maxAge time.Duration, emit func(i int, city CityWeatherResponse)) (TodayMatchesWeatherResponse, error) {
+	// Configure Hystrix for the "get-today-matches" command
+	hystrix.ConfigureCommand("get-today-matches", hystrix.CommandConfig{
 		Timeout:               10000, // Timeout in milliseconds
 		MaxConcurrentRequests: 10,    // Max concurrent requests
 		ErrorPercentThreshold: 25,    // Error percentage threshold for circuit breaker
 	})
 
-	for city := range citiesMap {
-		// Replace spaces with "&" for multi-word cities
-		cityQuery := strings.ReplaceAll(city, " ", "-")
-
-		// Use Hystrix for the weather request
-		err := hystrix.Do("get-current-weather", func() error {
-			var err error
-			weatherURL := RoundRobinBalancer() + "/current_weather?city=" + cityQuery
-			weatherResp, err := http.Get(weatherURL)
-			if err != nil {
-				return err
-			}
-			defer weatherResp.Body.Close()
-
-			weatherBody, err := io.ReadAll(weatherResp.Body)
-			if err != nil {
-				return err
-			}
-
-			// Parse the weather response
-			var currentWeather CurrentWeatherResponse
-			if err := json.Unmarshal(weatherBody, &currentWeather); err != nil {
-				return err
-			}
-
-			weatherResponses = append(weatherResponses, struct {
-				City    string                 `json:"city"`
-				Weather CurrentWeatherResponse `json:"weather"`
-			}{
-				City:    city,
-				Weather: currentWeather,
-			})
+	// Step 1: Get today's matches using Hystrix
+	matchesURL := MatchesBalancer() + "/today_matches?" + url.Values{"date": {today}}.Encode()
+	var matchesBody []byte
+	var err error
+	if maxAge > 0 {
+		matchesBody, err = g.cachedUpstreamFor(ctx, "live_today_matches_"+today, "get-today-matches", matchesURL, maxAge)
+	} else {
+		_, matchesBody, err = callUpstream(ctx, "get-today-matches", matchesURL)
+	}
+	if err != nil {
+		return TodayMatchesWeatherResponse{}, err
+	}
 
-			return nil
-		}, nil)
+	// Parse the matches response
+	var matches []Match // Replace Match with the actual struct type for your matches
+	if err := json.Unmarshal(matchesBody, &matches); err != nil {
+		return TodayMatchesWeatherResponse{}, err
+	}
 
-		if err != nil {
-			http.Error(w, "Error making request to weather microservice for current weather", http.StatusInternalServerError)
-			return
+	// Step 2: Find unique locations where matches are held, in the order they first appear
+	var cityMatches []Match
+	var locations []string
+	var locationMatches [][]Match
+	citiesMap := make(map[string]int)
+	for _, match := range matches {
+		location := g.resolveLocation(ctx, match)
+		if i, ok := citiesMap[location]; ok {
+			locationMatches[i] = append(locationMatches[i], match)
+			continue
 		}
+		citiesMap[location] = len(locations)
+		cityMatches = append(cityMatches, match)
+		locations = append(locations, location)
+		locationMatches = append(locationMatches, []Match{match})
 	}
 
-	// Step 4: Combine the responses and return to the user
-	response := struct {
-		Weather []struct {
-			City    string                 `json:"city"`
-			Weather CurrentWeatherResponse `json:"weather"`
-		} `json:"weather"`
-	}{
-		Weather: weatherResponses,
+	// Configure Hystrix for the "get-current-weather" command
+	hystrix.ConfigureCommand("get-current-weather", hystrix.CommandConfig{
+		Timeout:               10000,                        // Timeout in milliseconds
+		MaxConcurrentRequests: weatherCommandMaxConcurrency, // Max concurrent requests
+		ErrorPercentThreshold: 25,                           // Error percentage threshold for circuit breaker
+	})
+
+	// Step 3: Get current weather for each city using Hystrix, reusing getCurrentWeather's cache entries
+	var lookups weatherLookups
+	keyPrefix := "current_weather_"
+	if maxAge > 0 {
+		keyPrefix = "live_current_weather_"
+		lookups.ttl = maxAge
 	}
+	for _, location := range locations {
+		lookups.add(keyPrefix+location+"_"+today, "/current_weather?"+url.Values{"city": {location}}.Encode())
+	}
+	// Step 4: Combine the responses, in the order the cities first appear
+	response := TodayMatchesWeatherResponse{Weather: make([]CityWeatherResponse, len(cityMatches))}
+	g.streamWeatherLookups(ctx, "get-current-weather", &lookups, func(i int, body []byte, err error) {
+		var currentWeather CurrentWeatherResponse
+		if err == nil {
+			err = decodeWeather(body, &currentWeather)
+		}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+		response.Weather[i] = CityWeatherResponse{
+			City:    cityMatches[i].City,
+			Weather: currentWeather,
+			Matches: locationMatches[i],
+			Venue:   g.matchVenue(ctx, cityMatches[i]),
+			Error:   newItemError("Error making request to weather microservice for current weather", err),
+		}
+		if response.Weather[i].Error != nil {
+			response.Partial = true
+		}
+		emit(i, response.Weather[i])
+	})
+	return response, nil
+}
 
-	// Step 7: Cache the result in Redis with an expiration time
-	redisClient.Set(context.Background(), cacheKey, string(matchesBody), time.Hour)
+// PastMatchesMeteoResponse is the weather history of every match played on one day
+type PastMatchesMeteoResponse struct {
+	Partial bool                        `json:"partial"`
+	Weather []CombinedPastMatchResponse `json:"weather_history"`
 }
 
-func getPastMatchesMeteo(w http.ResponseWriter, r *http.Request) {
+func (g *Gateway) getPastMatchesMeteo(w http.ResponseWriter, r *http.Request) {
+	// A from/to range is resolved day by day
+	if r.URL.Query().Get("from") != "" || r.URL.Query().Get("to") != "" {
+		g.getPastMatchesMeteoRange(w, r)
+		return
+	}
+
 	// Get query parameter from the incoming request
 	targetDate := r.URL.Query().Get("date")
 
@@ -972,17 +917,99 @@ func getPastMatchesMeteo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The full-day view keeps all hourly entries instead of only the faceoff window
+	fullDay := r.URL.Query().Get("full_day") == "true"
+
 	// Create a cache key based on the target date
-	cacheKey := "past_matches_meteo_" + targetDate
+	cacheKey := pastMatchesMeteoCacheKey(targetDate, fullDay)
+
+	// Matches are streamed one by one when the client asks for NDJSON or server-sent events
+	stream := newAggregationStream(w, r)
 
 	// Check if the result is already in the cache
-	cachedResult, err := redisClient.Get(context.Background(), cacheKey).Result()
+	cachedResult, err := g.cache.Get(r.Context(), cacheKey)
 	if err == nil {
-		// If cached result is found, return it
-		w.Write([]byte(cachedResult))
+		var cached PastMatchesMeteoResponse
+		if stream == nil {
+			// If cached result is found, return it
+			w.Write([]byte(cachedResult))
+			return
+		} else if json.Unmarshal([]byte(cachedResult), &cached) == nil {
+			for _, combined := range cached.Weather {
+				stream.item(combined.UID, combined, combined.Error)
+			}
+			stream.finish()
+			return
+		}
+	}
+
+	var response PastMatchesMeteoResponse
+	var responseBody []byte
+	if stream != nil {
+		matches, matchesErr := g.pastMatches(r.Context(), targetDate)
+		if matchesErr != nil {
+			http.Error(w, matchesErr.Message, http.StatusInternalServerError)
+			return
+		}
+		response.Weather = make([]CombinedPastMatchResponse, len(matches))
+		g.streamPastMatchesWeather(r.Context(), matches, fullDay, func(i int, combined CombinedPastMatchResponse) {
+			response.Weather[i] = combined
+			if combined.Error != nil {
+				response.Partial = true
+			}
+			stream.item(combined.UID, combined, combined.Error)
+		})
+		stream.finish()
+		responseBody, err = json.Marshal(response)
+	} else {
+		var matchesErr *ItemError
+		response, matchesErr = g.pastMatchesMeteoForDate(r.Context(), targetDate, fullDay)
+		if matchesErr != nil {
+			http.Error(w, matchesErr.Message, http.StatusInternalServerError)
+			return
+		}
+		responseBody, err = writeAggregation(w, response, response.Partial)
+	}
+	if err != nil {
+		http.Error(w, "Error encoding past matches weather response", http.StatusInternalServerError)
 		return
 	}
 
+	if !response.Partial {
+		g.cache.Set(context.Background(), cacheKey, string(responseBody), time.Hour)
+	}
+}
+
+// pastMatchesMeteoCacheKey returns the cache key of one day of getPastMatchesMeteo
+func pastMatchesMeteoCacheKey(targetDate string, fullDay bool) string {
+	cacheKey := "past_matches_meteo_" + targetDate
+	if fullDay {
+		cacheKey += "_full_day"
+	}
+	return cacheKey
+}
+
+// pastMatchesMeteoForDate fetches the matches played on a date and the weather history at each of them.
+// It only returns an error when the matches can't be fetched; failed weather lookups are reported per item.
+func (g *Gateway) pastMatchesMeteoForDate(ctx context.Context, targetDate string, fullDay bool) (PastMatchesMeteoResponse, *ItemError) {
+	matches, matchesErr := g.pastMatches(ctx, targetDate)
+	if matchesErr != nil {
+		return PastMatchesMeteoResponse{}, matchesErr
+	}
+
+	// Step 3: Combine the responses
+	response := PastMatchesMeteoResponse{Weather: make([]CombinedPastMatchResponse, len(matches))}
+	g.streamPastMatchesWeather(ctx, matches, fullDay, func(i int, combined CombinedPastMatchResponse) {
+		response.Weather[i] = combined
+		if combined.Error != nil {
+			response.Partial = true
+		}
+	})
+	return response, nil
+}
+
+// pastMatches fetches the matches played on a date
+func (g *Gateway) pastMatches(ctx context.Context, targetDate string) ([]PastMatch, *ItemError) {
 	// Configure Hystrix for the "get-past-matches" command
 	hystrix.ConfigureCommand("get-past-matches", hystrix.CommandConfig{
 		Timeout:               10000, // Timeout in milliseconds
@@ -991,98 +1018,70 @@ func getPastMatchesMeteo(w http.ResponseWriter, r *http.Request) {
 	})
 
 	// Step 1: Get past matches using Hystrix
-	var matchesResp *http.Response
-	err = hystrix.Do("get-past-matches", func() error {
-		var err error
-		matchesURL := MatchesBalancer() + "/past_matches?target_date=" + targetDate
-		matchesResp, err = http.Get(matchesURL)
-		return err
-	}, nil)
-
+	matchesURL := MatchesBalancer() + "/past_matches?target_date=" + targetDate
+	_, matchesBody, err := callUpstream(ctx, "get-past-matches", matchesURL)
 	if err != nil {
-		http.Error(w, "Error making request to matches_ms for past matches", http.StatusInternalServerError)
-		return
-	}
-	defer matchesResp.Body.Close()
-
-	matchesBody, err := io.ReadAll(matchesResp.Body)
-	if err != nil {
-		http.Error(w, "Error reading response from matches_ms for past matches", http.StatusInternalServerError)
-		return
+		return nil, newItemError("Error making request to matches_ms for past matches", err)
 	}
 
 	// Parse the matches response
 	var matches []PastMatch
 	if err := json.Unmarshal(matchesBody, &matches); err != nil {
-		http.Error(w, "Error parsing past matches response", http.StatusInternalServerError)
-		return
+		return nil, newItemError("Error parsing past matches response", err)
 	}
+	return matches, nil
+}
 
-	// Step 2: Get weather history for each city using Hystrix
-	var combinedResponses []CombinedPastMatchResponse
-
+// streamPastMatchesWeather looks up the weather history at each match and hands it to emit as soon as it is known.
+// emit runs on the calling goroutine.
+func (g *Gateway) streamPastMatchesWeather(ctx context.Context, matches []PastMatch, fullDay bool, emit func(i int, combined CombinedPastMatchResponse)) {
+	// Step 2: Get weather history for each city using Hystrix, several matches at a time
 	// Configure Hystrix for the "get-weather-history" command
 	hystrix.ConfigureCommand("get-weather-history", hystrix.CommandConfig{
-		Timeout:               10000, // Timeout in milliseconds
-		MaxConcurrentRequests: 10,    // Max concurrent requests
-		ErrorPercentThreshold: 25,    // Error percentage threshold for circuit breaker
+		Timeout:               10000,                        // Timeout in milliseconds
+		MaxConcurrentRequests: weatherCommandMaxConcurrency, // Max concurrent requests
+		ErrorPercentThreshold: 25,                           // Error percentage threshold for circuit breaker
 	})
 
-	for _, match := range matches {
-		// Escape and replace spaces with "&" for multi-word cities
-		cityName := url.QueryEscape(match.City)
-		cityName = strings.ReplaceAll(cityName, "+", "&")
-
-		// Use Hystrix for the weather history request
-		err := hystrix.Do("get-weather-history", func() error {
-			var err error
-			weatherURL := RoundRobinBalancer() + "/weather_history?location=" + cityName + "&date=" + match.Date
-			weatherResp, err := http.Get(weatherURL)
-			if err != nil {
-				return err
-			}
-			defer weatherResp.Body.Close()
+	// Matches in the same city on the same local day share one lookup, keyed like getWeatherHistory's cache entries
+	var lookups weatherLookups
+	lookupMatches := make(map[int][]int)
+	faceoffs := make([]faceoff, len(matches))
+	for i, match := range matches {
+		location := g.resolveLocation(ctx, Match(match))
+		faceoffs[i] = g.matchFaceoff(ctx, Match(match))
+
+		lookup := lookups.add("weather_history_"+location+"_"+faceoffs[i].date(),
+			"/weather_history?"+url.Values{"location": {location}, "date": {faceoffs[i].date()}}.Encode())
+		lookupMatches[lookup] = append(lookupMatches[lookup], i)
+	}
 
-			weatherBody, err := io.ReadAll(weatherResp.Body)
-			if err != nil {
-				return err
+	g.streamWeatherLookups(ctx, "get-weather-history", &lookups, func(lookup int, body []byte, err error) {
+		for _, i := range lookupMatches[lookup] {
+			var weatherHistory WeatherHistoryResponse
+			err := err
+			if err == nil {
+				err = decodeWeather(body, &weatherHistory)
 			}
 
-			// Parse the weather response
-			var weatherHistory WeatherHistoryResponse
-			if err := json.Unmarshal(weatherBody, &weatherHistory); err != nil {
-				return err
+			window, atFaceoff := g.faceoffWindow(faceoffs[i], weatherHistory.HourlyWeather)
+			if !fullDay {
+				weatherHistory.HourlyWeather = window
 			}
 
-			combinedResponses = append(combinedResponses, CombinedPastMatchResponse{
-				City:          match.City,
-				UID:           match.UID,
+			emit(i, CombinedPastMatchResponse{
+				City:          matches[i].City,
+				UID:           matches[i].UID,
 				CityName:      weatherHistory.CityName,
 				Date:          weatherHistory.Date,
 				HourlyWeather: weatherHistory.HourlyWeather,
+				AtFaceoff:     atFaceoff,
+				Match:         (*Match)(&matches[i]),
+				Venue:         g.matchVenue(ctx, Match(matches[i])),
+				Error:         newItemError("Error making request to weather microservice for weather history", err),
 			})
-
-			return nil
-		}, nil)
-
-		if err != nil {
-			http.Error(w, "Error making request to weather microservice for weather history", http.StatusInternalServerError)
-			return
 		}
-	}
-
-	// Step 3: Combine the responses and return to the user
-	response := struct {
-		Weather []CombinedPastMatchResponse `json:"weather_history"`
-	}{
-		Weather: combinedResponses,
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
-
-	redisClient.Set(context.Background(), cacheKey, string(matchesBody), time.Hour)
+	})
 }
 
 // HealthCheckResponse represents the response for the health check endpoint
@@ -1095,13 +1094,13 @@ func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	gatewayStatus := "ok"
 
 	// Check the health of weather microservices
-	weatherHealth := checkMicroserviceHealth(weatherHostnames)
+	weatherHealth := checkMicroserviceHealth(r.Context(), weatherHostnames)
 	if !weatherHealth {
 		gatewayStatus = "unhealthy"
 	}
 
 	// Check the health of matches microservices
-	matchesHealth := checkMicroserviceHealth(matchesHostnames)
+	matchesHealth := checkMicroserviceHealth(r.Context(), matchesHostnames)
 	if !matchesHealth {
 		gatewayStatus = "unhealthy"
 	}
@@ -1116,16 +1115,17 @@ func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func getMatchesWeatherForecastTimeoutException(w http.ResponseWriter, r *http.Request) {
-	// Configure Hystrix settings for "getMatches" command
-	hystrix.ConfigureCommand("getMatches", hystrix.CommandConfig{
+func (g *Gateway) getMatchesWeatherForecastTimeoutException(w http.ResponseWriter, r *http.Request) {
+	// Configure Hystrix settings for "getMatchesTimeoutException" command. The demo has its own commands,
+	// so its short timeout doesn't change the limits of the other routes.
+	hystrix.ConfigureCommand("getMatchesTimeoutException", hystrix.CommandConfig{
 		Timeout:               1000, // Timeout in milliseconds
 		MaxConcurrentRequests: 10,   // Maximum number of concurrent requests
 		ErrorPercentThreshold: 25,   // Error percentage threshold
 	})
 
-	// Configure Hystrix settings for "getWeather" command
-	hystrix.ConfigureCommand("getWeather", hystrix.CommandConfig{
+	// Configure Hystrix settings for "getWeatherTimeoutException" command
+	hystrix.ConfigureCommand("getWeatherTimeoutException", hystrix.CommandConfig{
 		Timeout:               1000, // Timeout in milliseconds
 		MaxConcurrentRequests: 10,   // Maximum number of concurrent requests
 		ErrorPercentThreshold: 25,   // Error percentage threshold
@@ -1133,26 +1133,11 @@ func getMatchesWeatherForecastTimeoutException(w http.ResponseWriter, r *http.Re
 
 	// Step 1: Get upcoming matches
 	matchesURL := MatchesBalancer() + "/upcoming_matches"
-	var matchesResp *http.Response
-	err := hystrix.Do("getMatches", func() error {
-		resp, err := http.Get(matchesURL)
-		if err != nil {
-			return err
-		}
-		matchesResp = resp
-		return nil
-	}, nil)
+	_, matchesBody, err := callUpstream(r.Context(), "getMatchesTimeoutException", matchesURL)
 	if err != nil {
 		http.Error(w, "Error making request to matches_ms for upcoming matches", http.StatusInternalServerError)
 		return
 	}
-	defer matchesResp.Body.Close()
-
-	matchesBody, err := io.ReadAll(matchesResp.Body)
-	if err != nil {
-		http.Error(w, "Error reading response from matches_ms for upcoming matches", http.StatusInternalServerError)
-		return
-	}
 	// Parse the matches response
 	var matches []Match // Replace Match with the actual struct type for your matches
 	if err := json.Unmarshal(matchesBody, &matches); err != nil {
@@ -1168,30 +1153,14 @@ func getMatchesWeatherForecastTimeoutException(w http.ResponseWriter, r *http.Re
 			continue
 		}
 
-		// Replace spaces with "&" for multi-word cities
-		cityQuery := strings.ReplaceAll(match.City, " ", "-")
+		location := g.resolveLocation(r.Context(), match)
 
-		weatherURL := RoundRobinBalancer() + "/weather_forecast?location=" + cityQuery + "&date=" + match.Date
-		var weatherResp *http.Response
-		err := hystrix.Do("getWeather", func() error {
-			resp, err := http.Get(weatherURL)
-			if err != nil {
-				return err
-			}
-			weatherResp = resp
-			return nil
-		}, nil)
+		weatherURL := RoundRobinBalancer() + "/weather_forecast?" + url.Values{"location": {location}, "date": {match.Date}}.Encode()
+		_, weatherBody, err := callUpstream(r.Context(), "getWeatherTimeoutException", weatherURL)
 		if err != nil {
 			http.Error(w, "Error making request to weather microservice", http.StatusInternalServerError)
 			return
 		}
-		defer weatherResp.Body.Close()
-
-		weatherBody, err := io.ReadAll(weatherResp.Body)
-		if err != nil {
-			http.Error(w, "Error reading response from weather microservice", http.StatusInternalServerError)
-			return
-		}
 
 		// Parse the weather response
 		var forecast WeatherForecastResponse
@@ -1217,39 +1186,73 @@ func getMatchesWeatherForecastTimeoutException(w http.ResponseWriter, r *http.Re
 }
 
 // checkMicroserviceHealth checks the health of a microservice by sending a simple request
-func checkMicroserviceHealth(endpoints []string) bool {
+func checkMicroserviceHealth(ctx context.Context, endpoints []string) bool {
 
 	for _, endpoint := range endpoints {
-		resp, err := http.Get(endpoint + "/status")
-		if err != nil || resp.StatusCode != http.StatusOK {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"/status", nil)
+		if err != nil {
+			return false
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
 			return false
 		}
-		defer resp.Body.Close()
 	}
 
 	return true
 }
 
 func main() {
-	http.HandleFunc("/weather/forward_weather_forecast", getWeatherRequest)
-	http.HandleFunc("/weather/get_weather_history", getWeatherHistory)
-	http.HandleFunc("/weather/get_current_weather", getCurrentWeather)
-	http.HandleFunc("/weather/get_astro", getAstroInfo)
-
-	http.HandleFunc("/matches/upcoming_matches", getUpcomingMatches)
-	http.HandleFunc("/matches/get_today_matches", getTodayMatches)
-	http.HandleFunc("/matches/past_matches", getPastMatches)
-	http.HandleFunc("/matches/team_info", getTeamInfo)
+	g, err := NewGateway(loadConfig())
+	if err != nil {
+		fmt.Println("Error creating the gateway:", err)
+		return
+	}
 
-	http.HandleFunc("/meteo_for_future_matches", getMatchesWeatherForecast)
-	http.HandleFunc("/meteo_for_today_matches", getTodayMatchesAndWeather)
-	http.HandleFunc("/past_matches_meteo", getPastMatchesMeteo)
-	http.HandleFunc("/get_meteo_for_future_matches_timeout_exception", getMatchesWeatherForecastTimeoutException)
+	http.HandleFunc("/weather/forward_weather_forecast", g.getWeatherRequest)
+	http.HandleFunc("/weather/get_weather_history", g.getWeatherHistory)
+	http.HandleFunc("/weather/get_current_weather", g.getCurrentWeather)
+	http.HandleFunc("/weather/get_astro", g.getAstroInfo)
+
+	http.HandleFunc("/matches/upcoming_matches", withResponseView(g.getUpcomingMatches))
+	http.HandleFunc("/matches/get_today_matches", withResponseView(g.getTodayMatches))
+	http.HandleFunc("/matches/past_matches", withResponseView(g.getPastMatches))
+	http.HandleFunc("/matches/team_info", g.getTeamInfo)
+	http.HandleFunc("/matches/schedule_changes", g.getScheduleChanges)
+	http.HandleFunc("/matches/", g.getMatchDetail)
+	http.HandleFunc("/teams/schedule", withResponseView(g.getTeamSchedule, "team"))
+	http.HandleFunc("/alerts", withResponseView(g.getWeatherAlerts))
+	http.HandleFunc("/forecast_accuracy", g.getForecastAccuracy)
+	http.HandleFunc("/forecast_versions", g.getForecastVersions)
+
+	http.HandleFunc("/meteo_for_future_matches", withResponseView(g.getMatchesWeatherForecast))
+	http.HandleFunc("/meteo_for_today_matches", withResponseView(g.getTodayMatchesAndWeather))
+	http.HandleFunc("/past_matches_meteo", withResponseView(g.getPastMatchesMeteo))
+	http.HandleFunc("/get_meteo_for_future_matches_timeout_exception", g.getMatchesWeatherForecastTimeoutException)
+	http.HandleFunc("/live/today", g.getLiveFeed)
+	http.HandleFunc("/jobs", g.jobsHandler)
+	http.HandleFunc("/jobs/", g.jobsHandler)
+
+	http.HandleFunc("/admin/venues", g.venuesHandler)
+	http.HandleFunc("/admin/venues/", g.venuesHandler)
+	http.HandleFunc("/admin/webhooks", g.webhooksHandler)
+	http.HandleFunc("/admin/webhooks/", g.webhooksHandler)
 
 	http.HandleFunc("/status", healthCheckHandler)
+	http.Handle("/metrics", promhttp.Handler())
+
+	g.startCacheWarmer()
+	g.startAlertScanner()
+	g.startWebhookWorker()
+	g.startJobWorkers()
+	g.startForecastAccuracyJob()
 
 	fmt.Println("Server is running on http://localhost:8080")
-	err := http.ListenAndServe(":8080", nil)
+	err = http.ListenAndServe(":8080", g.withClockOverride(http.DefaultServeMux))
 	if err != nil {
 		fmt.Println("Error starting the server:", err)
 	}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/afex/hystrix-go/hystrix"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// AstroResponse is the sun and moon times returned by the weather microservice's /astro endpoint
+type AstroResponse struct {
+	CityName string `json:"city_name"`
+	Date     string `json:"date"`
+	Sunrise  string `json:"sunrise"`
+	Sunset   string `json:"sunset"`
+	Moonrise string `json:"moonrise"`
+	Moonset  string `json:"moonset"`
+}
+
+// MatchWeather is the weather at a match's venue: a forecast for upcoming matches, the history for past ones
+type MatchWeather struct {
+	Source        string                   `json:"source"` // "forecast", "climatology" or "history"
+	Date          string                   `json:"date"`
+	HourlyWeather map[string]HourlyWeather `json:"hourly_weather"`
+	AtFaceoff     *FaceoffWeather          `json:"at_faceoff,omitempty"`
+}
+
+// MatchDetailResponse is everything a game page needs. Sections that could not be loaded are left out
+// and their error is reported under the section's name in Errors.
+type MatchDetailResponse struct {
+	Partial bool                  `json:"partial"`
+	Match   Match                 `json:"match"`
+	Venue   *Venue                `json:"venue,omitempty"`
+	Teams   *TeamInfoResponse     `json:"teams,omitempty"`
+	Weather *MatchWeather         `json:"weather,omitempty"`
+	Astro   *AstroResponse        `json:"astro,omitempty"`
+	Errors  map[string]*ItemError `json:"errors,omitempty"`
+}
+
+// matchDetailSections are loaded in parallel, each by its own fetch
+var matchDetailSections = []string{"teams", "weather", "astro"}
+
+// matchDetailSection is what loading one section returns: its content, or the error that kept it out
+type matchDetailSection struct {
+	teams   *TeamInfoResponse
+	weather *MatchWeather
+	astro   *AstroResponse
+	err     *ItemError
+}
+
+// getMatchDetail handles /matches/{uid}. The match is looked up among the upcoming and today's matches,
+// or among the matches of the optional date parameter for past games.
+func (g *Gateway) getMatchDetail(w http.ResponseWriter, r *http.Request) {
+	uid := strings.Trim(strings.TrimPrefix(r.URL.Path, "/matches/"), "/")
+	if uid == "" || strings.Contains(uid, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	today, ok := g.requestToday(w, r)
+	if !ok {
+		return
+	}
+
+	match, found, err := g.findMatch(r.Context(), uid, today, r.URL.Query().Get("date"))
+	if err != nil && !found {
+		http.Error(w, "Error making request to matches microservice for match "+uid, http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "Match not found", http.StatusNotFound)
+		return
+	}
+
+	response := MatchDetailResponse{
+		Match: match,
+		Venue: g.matchVenue(r.Context(), match),
+	}
+
+	// Each section returns its result, and the response is assembled here once all of them are back
+	sections := fanOut(r.Context(), len(matchDetailSections), len(matchDetailSections), func(ctx context.Context, i int) matchDetailSection {
+		switch matchDetailSections[i] {
+		case "teams":
+			teams, err := g.teamInfo(ctx, match.UID)
+			if err != nil {
+				return matchDetailSection{err: newItemError("Error making request to matches microservice for team info", err)}
+			}
+			return matchDetailSection{teams: &teams}
+		case "weather":
+			weather, err := g.matchWeather(ctx, match)
+			if err != nil {
+				return matchDetailSection{err: newItemError("Error making request to weather microservice", err)}
+			}
+			return matchDetailSection{weather: &weather}
+		case "astro":
+			astro, err := g.matchAstro(ctx, match)
+			if err != nil {
+				return matchDetailSection{err: newItemError("Error making request to weather microservice for astro information", err)}
+			}
+			return matchDetailSection{astro: &astro}
+		}
+		return matchDetailSection{}
+	})
+
+	for i, section := range sections {
+		if section.err != nil {
+			if response.Errors == nil {
+				response.Errors = make(map[string]*ItemError)
+			}
+			response.Errors[matchDetailSections[i]] = section.err
+			response.Partial = true
+			continue
+		}
+		switch {
+		case section.teams != nil:
+			response.Teams = section.teams
+		case section.weather != nil:
+			response.Weather = section.weather
+		case section.astro != nil:
+			response.Astro = section.astro
+		}
+	}
+
+	if _, err := writeAggregation(w, response, response.Partial); err != nil {
+		http.Error(w, "Error encoding match detail response", http.StatusInternalServerError)
+	}
+}
+
+// findMatch looks a match up by UID among the upcoming matches, today's matches and, when a date is given,
+// the matches played on that date. The error is the last failed lookup and can be set even if the match was found.
+func (g *Gateway) findMatch(ctx context.Context, uid string, today string, date string) (Match, bool, error) {
+	var lastErr error
+	if matches, err := g.upcomingMatches(ctx, today); err == nil {
+		for _, match := range matches {
+			if match.UID == uid {
+				return match, true, nil
+			}
+		}
+	} else {
+		lastErr = err
+	}
+
+	hystrix.ConfigureCommand("getTodayMatches", hystrix.CommandConfig{
+		Timeout:               8000, // Timeout in milliseconds
+		MaxConcurrentRequests: 100,  // Maximum number of concurrent requests
+		ErrorPercentThreshold: 25,   // Error percentage threshold for circuit breaker
+	})
+	hystrix.ConfigureCommand("getPastMatches", hystrix.CommandConfig{
+		Timeout:               10000, // Timeout in milliseconds
+		MaxConcurrentRequests: 100,   // Maximum number of concurrent requests
+		ErrorPercentThreshold: 25,    // Error percentage threshold for circuit breaker
+	})
+
+	// The same cache keys as getTodayMatches and getPastMatches
+	sources := []struct{ cacheKey, command, url string }{
+		{"today_matches_" + today, "getTodayMatches", MatchesBalancer() + "/today_matches?" + url.Values{"date": {today}}.Encode()},
+	}
+	if date != "" {
+		sources = append(sources, struct{ cacheKey, command, url string }{
+			"past_matches_" + date, "getPastMatches", MatchesBalancer() + "/past_matches?" + url.Values{"target_date": {date}}.Encode(),
+		})
+	}
+
+	for _, source := range sources {
+		body, err := g.cachedUpstream(ctx, source.cacheKey, source.command, source.url)
+		var matches []Match
+		if err == nil {
+			err = json.Unmarshal(body, &matches)
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, match := range matches {
+			if match.UID == uid {
+				return match, true, lastErr
+			}
+		}
+	}
+	return Match{}, false, lastErr
+}
+
+// matchWeather returns the forecast for a match that hasn't been played yet, or the weather history
+// for one that has, aligned with its faceoff. Matches beyond the forecast horizon get a climatological estimate.
+func (g *Gateway) matchWeather(ctx context.Context, match Match) (MatchWeather, error) {
+	location := g.resolveLocation(ctx, match)
+	start := g.matchFaceoff(ctx, match)
+	date := start.date()
+
+	weather := MatchWeather{Source: sourceForecast, Date: date}
+	now := g.now(ctx).In(start.at.Location())
+	if start.at.Before(now) && date != now.Format(matchDateLayout) {
+		weather.Source = "history"
+	} else if g.beyondForecastHorizon(ctx, start) {
+		weather.Source = sourceClimatology
+		estimate, err := g.climatology(ctx, location, date)
+		if err != nil {
+			return weather, err
+		}
+		weather.HourlyWeather = estimate.HourlyWeather
+		_, weather.AtFaceoff = g.faceoffWindow(start, estimate.HourlyWeather)
+		return weather, nil
+	}
+
+	query := url.Values{"location": {location}, "date": {date}}.Encode()
+	var body []byte
+	var err error
+	if weather.Source == "history" {
+		hystrix.ConfigureCommand("getWeatherHistory", hystrix.CommandConfig{
+			Timeout:               10000, // Timeout in milliseconds
+			MaxConcurrentRequests: 100,   // Maximum number of concurrent requests
+			ErrorPercentThreshold: 25,    // Error percentage threshold for circuit breaker
+		})
+		// The same cache key as getWeatherHistory
+		body, err = g.cachedUpstream(ctx, "weather_history_"+location+"_"+date, "getWeatherHistory",
+			RoundRobinBalancer()+"/weather_history?"+query)
+	} else {
+		hystrix.ConfigureCommand("getWeatherRequest", hystrix.CommandConfig{
+			Timeout:               20000, // Timeout in milliseconds
+			MaxConcurrentRequests: 100,   // Maximum number of concurrent requests
+			ErrorPercentThreshold: 25,    // Error percentage threshold for circuit breaker
+		})
+		// The same cache key as getWeatherRequest
+		var fetched bool
+		body, fetched, err = g.cachedUpstreamFetched(ctx, location+"_"+date, "getWeatherRequest",
+			RoundRobinBalancer()+"/weather_forecast?"+query, time.Hour)
+		if fetched {
+			g.recordForecastVersion(ctx, location, date, body)
+		}
+	}
+	if err != nil {
+		return weather, err
+	}
+
+	// Forecasts and history share the hourly_weather field
+	var decoded WeatherHistoryResponse
+	if err := decodeWeather(body, &decoded); err != nil {
+		return weather, err
+	}
+	weather.HourlyWeather = decoded.HourlyWeather
+	_, weather.AtFaceoff = g.faceoffWindow(start, decoded.HourlyWeather)
+	if weather.Source == sourceForecast {
+		g.recordForecastSnapshot(ctx, match, location, start, weather.AtFaceoff)
+	}
+	return weather, nil
+}
+
+// matchAstro returns the sun and moon times at a match's venue on its local date
+func (g *Gateway) matchAstro(ctx context.Context, match Match) (AstroResponse, error) {
+	hystrix.ConfigureCommand("getAstroInfo", hystrix.CommandConfig{
+		Timeout:               1000, // Timeout in milliseconds
+		MaxConcurrentRequests: 100,  // Maximum number of concurrent requests
+		ErrorPercentThreshold: 25,   // Error percentage threshold for circuit breaker
+	})
+
+	location := g.resolveLocation(ctx, match)
+	date := g.matchFaceoff(ctx, match).date()
+
+	var astro AstroResponse
+	// The same cache key as getAstroInfo
+	body, err := g.cachedUpstream(ctx, "astro_info_"+location+"_"+date, "getAstroInfo",
+		RoundRobinBalancer()+"/astro?"+url.Values{"city": {location}, "date": {date}}.Encode())
+	if err != nil {
+		return astro, err
+	}
+	err = json.Unmarshal(body, &astro)
+	return astro, err
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// cacheRequests counts cache lookups by result (hit/miss)
+	cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_cache_requests_total",
+		Help: "Total number of cache lookups by result",
+	}, []string{"result"})
+
+	// cacheWrittenBytes counts bytes written to the cache before (raw) and after (stored) compression
+	cacheWrittenBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_cache_written_bytes_total",
+		Help: "Total number of bytes written to the cache, before and after compression",
+	}, []string{"form"})
+
+	// cacheReadBytes counts bytes read from the cache as stored and once decompressed
+	cacheReadBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_cache_read_bytes_total",
+		Help: "Total number of bytes read from the cache, as stored and after decompression",
+	}, []string{"form"})
+)
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Defaults for paginating range responses
+const (
+	defaultRangePageSize = 50
+	maxRangePageSize     = 500
+)
+
+// DayError reports a day of a range whose matches could not be fetched
+type DayError struct {
+	Date  string     `json:"date"`
+	Error *ItemError `json:"error"`
+}
+
+// PastMatchesMeteoRangeResponse is one page of the matches played between two dates, with their weather history
+type PastMatchesMeteoRangeResponse struct {
+	Partial   bool                        `json:"partial"`
+	From      string                      `json:"from"`
+	To        string                      `json:"to"`
+	Page      int                         `json:"page"`
+	PageSize  int                         `json:"page_size"`
+	Total     int                         `json:"total"`
+	Weather   []CombinedPastMatchResponse `json:"weather_history"`
+	DayErrors []DayError                  `json:"day_errors,omitempty"`
+}
+
+// pastMeteoDay is the result of one day of a range
+type pastMeteoDay struct {
+	response PastMatchesMeteoResponse
+	err      *ItemError
+}
+
+// getPastMatchesMeteoRange returns the matches played from one date to another, with their weather history.
+// Each day is fetched and cached like a single-date request, so overlapping ranges reuse the same entries.
+func (g *Gateway) getPastMatchesMeteoRange(w http.ResponseWriter, r *http.Request) {
+	from, err := time.Parse(matchDateLayout, r.URL.Query().Get("from"))
+	if err != nil {
+		http.Error(w, "From must be a date in the dd.mm.yyyy format", http.StatusBadRequest)
+		return
+	}
+	to, err := time.Parse(matchDateLayout, r.URL.Query().Get("to"))
+	if err != nil {
+		http.Error(w, "To must be a date in the dd.mm.yyyy format", http.StatusBadRequest)
+		return
+	}
+	if to.Before(from) {
+		http.Error(w, "To must not be before from", http.StatusBadRequest)
+		return
+	}
+	days := int(to.Sub(from).Hours()/24) + 1
+	if days > g.config.PastRangeMaxDays {
+		http.Error(w, fmt.Sprintf("The range can't be longer than %d days", g.config.PastRangeMaxDays), http.StatusBadRequest)
+		return
+	}
+
+	page, ok := queryPositiveInt(w, r, "page", 1)
+	if !ok {
+		return
+	}
+	pageSize, ok := queryPositiveInt(w, r, "page_size", defaultRangePageSize)
+	if !ok {
+		return
+	}
+	pageSize = min(pageSize, maxRangePageSize)
+
+	fullDay := r.URL.Query().Get("full_day") == "true"
+
+	// Matches of the page are streamed one by one when the client asks for NDJSON or server-sent events
+	stream := newAggregationStream(w, r)
+
+	response := PastMatchesMeteoRangeResponse{
+		From:     from.Format(matchDateLayout),
+		To:       to.Format(matchDateLayout),
+		Page:     page,
+		PageSize: pageSize,
+		Weather:  []CombinedPastMatchResponse{},
+	}
+	// Filters are applied before paginating, so every page is full
+	filter := parseResponseView(r).filter
+	start := (page - 1) * pageSize
+
+	// addDay adds the matches of a day to the page; days are added in date order, so the page is the same
+	// whichever day finishes first
+	addDay := func(i int, day pastMeteoDay) {
+		if day.err != nil {
+			dayError := DayError{Date: from.AddDate(0, 0, i).Format(matchDateLayout), Error: day.err}
+			response.DayErrors = append(response.DayErrors, dayError)
+			response.Partial = true
+			if stream != nil {
+				stream.dayError(dayError)
+			}
+			return
+		}
+		if day.response.Partial {
+			response.Partial = true
+		}
+		for _, item := range day.response.Weather {
+			if item.Match != nil && !filter.matches(*item.Match) {
+				continue
+			}
+			if response.Total >= start && response.Total < start+pageSize {
+				response.Weather = append(response.Weather, item)
+				if stream != nil {
+					stream.item(item.UID, item, item.Error)
+				}
+			}
+			response.Total++
+		}
+	}
+
+	// Every day runs its own weather fan-out, so only as many days run at once as the weather command allows
+	finished := make(map[int]pastMeteoDay)
+	next := 0
+	fanOutEach(r.Context(), days, weatherCommandMaxConcurrency/g.aggregationWorkers(), func(ctx context.Context, i int) pastMeteoDay {
+		response, err := g.cachedPastMatchesMeteoForDate(ctx, from.AddDate(0, 0, i).Format(matchDateLayout), fullDay)
+		return pastMeteoDay{response: response, err: err}
+	}, func(i int, day pastMeteoDay) {
+		finished[i] = day
+		for ; next < days; next++ {
+			day, ok := finished[next]
+			if !ok {
+				break
+			}
+			delete(finished, next)
+			addDay(next, day)
+		}
+	})
+
+	if stream != nil {
+		stream.finish()
+		return
+	}
+	if _, err := writeAggregation(w, response, response.Partial); err != nil {
+		http.Error(w, "Error encoding past matches weather response", http.StatusInternalServerError)
+	}
+}
+
+// cachedPastMatchesMeteoForDate returns one day of past matches with their weather, from the cache when it can.
+// Complete days are written back under the same key a single-date request uses.
+func (g *Gateway) cachedPastMatchesMeteoForDate(ctx context.Context, targetDate string, fullDay bool) (PastMatchesMeteoResponse, *ItemError) {
+	cacheKey := pastMatchesMeteoCacheKey(targetDate, fullDay)
+
+	var response PastMatchesMeteoResponse
+	if cachedResult, err := g.cache.Get(ctx, cacheKey); err == nil {
+		if err := json.Unmarshal([]byte(cachedResult), &response); err == nil {
+			return response, nil
+		}
+	}
+
+	response, matchesErr := g.pastMatchesMeteoForDate(ctx, targetDate, fullDay)
+	if matchesErr != nil || response.Partial {
+		return response, matchesErr
+	}
+
+	if body, err := json.Marshal(response); err == nil {
+		g.cache.Set(context.Background(), cacheKey, string(body), time.Hour)
+	}
+	return response, nil
+}
+
+// queryPositiveInt reads an optional positive integer query parameter. When it is invalid it writes
+// a 400 response and returns false.
+func queryPositiveInt(w http.ResponseWriter, r *http.Request, name string, fallback int) (int, bool) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return fallback, true
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 1 {
+		http.Error(w, fmt.Sprintf("%s must be a positive integer", name), http.StatusBadRequest)
+		return 0, false
+	}
+	return value, true
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Cache keys of the last upcoming-matches snapshot, the changes found between snapshots and the lock
+// that lets one gateway at a time compare them
+const (
+	scheduleSnapshotKey = "schedule_snapshot"
+	scheduleChangesKey  = "schedule_changes"
+	scheduleLockKey     = "schedule_snapshot_lock"
+)
+
+// scheduleLockTTL bounds how long a crashed gateway can hold the snapshot lock
+const scheduleLockTTL = 30 * time.Second
+
+// teamScheduleGenerationPrefix is the cache key prefix of the generation of a date's team schedules.
+// The team schedules of a date are keyed by the team as the client gave it, so they can't be deleted one by one
+// when a match moves; a new generation makes every cached schedule of the date a miss instead.
+const teamScheduleGenerationPrefix = "team_schedule_generation_"
+
+// teamScheduleGenerationTTL outlives the team schedules cached under an older generation
+const teamScheduleGenerationTTL = 24 * time.Hour
+
+// Offsets of the timezones furthest behind and ahead of UTC. Requests can ask for any timezone, so the
+// date they take as today is one of the dates between the two.
+const (
+	earliestZoneOffset = -12 * time.Hour
+	latestZoneOffset   = 14 * time.Hour
+)
+
+// maxScheduleChanges caps the change list; the oldest entries are dropped first
+const maxScheduleChanges = 500
+
+// Types of schedule changes
+const (
+	scheduleAdded       = "added"
+	scheduleRemoved     = "removed"
+	scheduleRescheduled = "rescheduled"
+	scheduleRelocated   = "relocated"
+)
+
+// ScheduleChange is a difference between two upcoming-matches snapshots. Match is the match as it is now,
+// or as it was last seen when it was removed; Previous is the match before it was rescheduled or relocated.
+type ScheduleChange struct {
+	Type       string    `json:"type"`
+	UID        string    `json:"uid"`
+	Match      Match     `json:"match"`
+	Previous   *Match    `json:"previous,omitempty"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+// scheduleSnapshot is the upcoming-matches list of a date
+type scheduleSnapshot struct {
+	Date    string  `json:"date"` // YYYY-MM-DD
+	Matches []Match `json:"matches"`
+}
+
+// recordSchedule compares upcoming matches fetched from the matches microservice with the previous snapshot,
+// records the changes and invalidates the cached weather of the matches that moved, whichever timezone it was
+// requested in. Only lists of today in the
+// default timezone are compared, so requests in other timezones don't move the snapshot back and forth between
+// dates. Lists older than the snapshot are ignored, and so are lists fetched while another gateway is comparing one.
+func (g *Gateway) recordSchedule(ctx context.Context, today string, matches []Match) {
+	locker, ok := g.cache.(Locker)
+	if !ok {
+		return
+	}
+	if defaultToday, err := g.defaultToday(ctx); err != nil || today != defaultToday {
+		return
+	}
+	lockID := instanceID + "-" + newRandomID()
+	if acquired, err := locker.SetNX(ctx, scheduleLockKey, lockID, scheduleLockTTL); err != nil || !acquired {
+		return
+	}
+	defer func() {
+		if holder, err := g.cache.Get(context.Background(), scheduleLockKey); err == nil && holder == lockID {
+			g.cache.Delete(context.Background(), scheduleLockKey)
+		}
+	}()
+
+	var previous scheduleSnapshot
+	stored, err := g.cache.Get(ctx, scheduleSnapshotKey)
+	if err == nil {
+		err = json.Unmarshal([]byte(stored), &previous)
+	}
+	if err != nil && err != errCacheMiss {
+		return
+	}
+	if previous.Date > today {
+		return
+	}
+
+	// The first snapshot only sets the baseline
+	if err == nil {
+		changes := g.diffSchedules(ctx, previous, scheduleSnapshot{Date: today, Matches: matches})
+		if len(changes) > 0 {
+			g.addScheduleChanges(ctx, changes)
+			g.invalidateMovedMatches(ctx, requestDates(previous.Date, g.now(ctx)), changes)
+		}
+	}
+
+	if body, err := json.Marshal(scheduleSnapshot{Date: today, Matches: matches}); err == nil {
+		g.cache.Set(ctx, scheduleSnapshotKey, string(body), 0)
+	}
+}
+
+// diffSchedules compares two snapshots by match UID. Only matches from the later snapshot's date on are
+// compared, so matches that were played, or that an earlier date's list has and a later one doesn't,
+// are not reported as removed or added.
+func (g *Gateway) diffSchedules(ctx context.Context, previous, current scheduleSnapshot) []ScheduleChange {
+	start := max(previous.Date, current.Date)
+	now := g.now(ctx)
+	inWindow := func(match Match) bool {
+		at := g.matchFaceoff(ctx, match).at
+		return at.Format(dateKeyLayout) >= start
+	}
+
+	before := make(map[string]Match)
+	for _, match := range previous.Matches {
+		before[match.UID] = match
+	}
+	after := make(map[string]Match)
+	for _, match := range current.Matches {
+		after[match.UID] = match
+	}
+
+	detectedAt := now.UTC()
+	var changes []ScheduleChange
+	for _, match := range current.Matches {
+		old, seen := before[match.UID]
+		if !seen {
+			if inWindow(match) {
+				changes = append(changes, ScheduleChange{Type: scheduleAdded, UID: match.UID, Match: match, DetectedAt: detectedAt})
+			}
+			continue
+		}
+
+		if match.StartTime != old.StartTime || match.Date != old.Date {
+			changes = append(changes, ScheduleChange{Type: scheduleRescheduled, UID: match.UID, Match: match, Previous: &old, DetectedAt: detectedAt})
+		}
+		if match.City != old.City || match.VenueFullName != old.VenueFullName || match.State != old.State || match.Country != old.Country {
+			changes = append(changes, ScheduleChange{Type: scheduleRelocated, UID: match.UID, Match: match, Previous: &old, DetectedAt: detectedAt})
+		}
+	}
+
+	for _, match := range previous.Matches {
+		if _, ok := after[match.UID]; ok {
+			continue
+		}
+		if inWindow(match) && g.matchFaceoff(ctx, match).at.After(now) {
+			changes = append(changes, ScheduleChange{Type: scheduleRemoved, UID: match.UID, Match: match, DetectedAt: detectedAt})
+		}
+	}
+	return changes
+}
+
+// scheduleChanges returns the recorded changes, oldest first
+func (g *Gateway) scheduleChanges(ctx context.Context) []ScheduleChange {
+	var changes []ScheduleChange
+	if stored, err := g.cache.Get(ctx, scheduleChangesKey); err == nil {
+		json.Unmarshal([]byte(stored), &changes)
+	}
+	return changes
+}
+
+// addScheduleChanges appends changes to the change list
+func (g *Gateway) addScheduleChanges(ctx context.Context, changes []ScheduleChange) {
+	all := append(g.scheduleChanges(ctx), changes...)
+	if len(all) > maxScheduleChanges {
+		all = all[len(all)-maxScheduleChanges:]
+	}
+	if body, err := json.Marshal(all); err == nil {
+		g.cache.Set(ctx, scheduleChangesKey, string(body), 0)
+	}
+}
+
+// requestDates returns the dates that are today in some timezone at the given time, oldest first, after the
+// date of the previous snapshot when it is older
+func requestDates(previous string, now time.Time) []string {
+	var dates []string
+	if previous != "" && previous < now.Add(earliestZoneOffset).UTC().Format(dateKeyLayout) {
+		dates = append(dates, previous)
+	}
+	last := now.Add(latestZoneOffset).UTC().Format(dateKeyLayout)
+	for day := now.Add(earliestZoneOffset).UTC(); day.Format(dateKeyLayout) <= last; day = day.AddDate(0, 0, 1) {
+		dates = append(dates, day.Format(dateKeyLayout))
+	}
+	return dates
+}
+
+// invalidateMovedMatches drops the cached responses that hold the weather of a rescheduled, relocated or removed
+// match at its old time or place. They are keyed by the date the request took as today, which depends on its
+// timezone, so the keys of every given date go.
+func (g *Gateway) invalidateMovedMatches(ctx context.Context, dates []string, changes []ScheduleChange) {
+	var keys []string
+	for _, change := range changes {
+		if change.Type == scheduleAdded {
+			continue
+		}
+
+		// The forecast snapshots are taken again at the new time and place
+		keys = append(keys, g.forecastSnapshotKeys(change.UID)...)
+	}
+	if len(keys) == 0 {
+		return
+	}
+
+	for _, date := range dates {
+		g.cache.Set(ctx, teamScheduleGenerationPrefix+date, newRandomID(), teamScheduleGenerationTTL)
+		keys = append(keys,
+			"upcoming_matches_"+date,
+			"today_matches_"+date,
+			"matches_weather_forecast_"+date,
+			"matches_weather_forecast_"+date+"_full_day",
+			"today_matches_and_weather_"+date,
+			"weather_alerts_"+date,
+		)
+	}
+	if err := g.cache.Delete(ctx, keys...); err != nil {
+		fmt.Println("Error invalidating the weather of moved matches:", err)
+	}
+}
+
+// getScheduleChanges handles /matches/schedule_changes. The optional since (RFC 3339 or YYYY-MM-DD),
+// type and uid parameters narrow the list down.
+func (g *Gateway) getScheduleChanges(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var since time.Time
+	if raw := query.Get("since"); raw != "" {
+		var err error
+		if since, err = parseClockTime(raw); err != nil {
+			http.Error(w, "Since must be an RFC 3339 time or a YYYY-MM-DD date", http.StatusBadRequest)
+			return
+		}
+	}
+	changeType := query.Get("type")
+	switch changeType {
+	case "", scheduleAdded, scheduleRemoved, scheduleRescheduled, scheduleRelocated:
+	default:
+		http.Error(w, "Type must be added, removed, rescheduled or relocated", http.StatusBadRequest)
+		return
+	}
+	uid := query.Get("uid")
+
+	changes := []ScheduleChange{}
+	for _, change := range g.scheduleChanges(r.Context()) {
+		if change.DetectedAt.Before(since) || (changeType != "" && change.Type != changeType) || (uid != "" && change.UID != uid) {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	writeJSON(w, http.StatusOK, changes)
+}
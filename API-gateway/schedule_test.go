@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiffSchedules(t *testing.T) {
+	g := newTestGateway(t, "2023-10-09T12:00:00Z")
+	withVenues(t, g)
+
+	upcoming := Match{UID: "1", Name: "Bruins at Rangers", City: "New York", StartTime: "2023-10-12T23:00Z"}
+	moved := upcoming
+	moved.StartTime = "2023-10-13T23:00Z"
+	relocated := upcoming
+	relocated.City = "Boston"
+	movedAndRelocated := relocated
+	movedAndRelocated.StartTime = moved.StartTime
+	playedToday := Match{UID: "2", Name: "Kings at Ducks", City: "Anaheim", StartTime: "2023-10-09T02:00Z"}
+	yesterday := Match{UID: "3", Name: "Jets at Wild", City: "Saint Paul", StartTime: "2023-10-08T00:00Z"}
+
+	tests := []struct {
+		name     string
+		previous scheduleSnapshot
+		current  scheduleSnapshot
+		want     []string // "<type> <uid>"
+	}{
+		{"unchanged",
+			scheduleSnapshot{Date: "2023-10-09", Matches: []Match{upcoming}},
+			scheduleSnapshot{Date: "2023-10-09", Matches: []Match{upcoming}},
+			nil},
+		{"added",
+			scheduleSnapshot{Date: "2023-10-09"},
+			scheduleSnapshot{Date: "2023-10-09", Matches: []Match{upcoming}},
+			[]string{"added 1"}},
+		{"added before the later list's date",
+			scheduleSnapshot{Date: "2023-10-09"},
+			scheduleSnapshot{Date: "2023-10-07", Matches: []Match{yesterday}},
+			nil},
+		{"rescheduled",
+			scheduleSnapshot{Date: "2023-10-09", Matches: []Match{upcoming}},
+			scheduleSnapshot{Date: "2023-10-09", Matches: []Match{moved}},
+			[]string{"rescheduled 1"}},
+		{"relocated",
+			scheduleSnapshot{Date: "2023-10-09", Matches: []Match{upcoming}},
+			scheduleSnapshot{Date: "2023-10-09", Matches: []Match{relocated}},
+			[]string{"relocated 1"}},
+		{"rescheduled and relocated",
+			scheduleSnapshot{Date: "2023-10-09", Matches: []Match{upcoming}},
+			scheduleSnapshot{Date: "2023-10-09", Matches: []Match{movedAndRelocated}},
+			[]string{"rescheduled 1", "relocated 1"}},
+		{"removed",
+			scheduleSnapshot{Date: "2023-10-09", Matches: []Match{upcoming, playedToday}},
+			scheduleSnapshot{Date: "2023-10-09", Matches: []Match{playedToday}},
+			[]string{"removed 1"}},
+		{"played today",
+			scheduleSnapshot{Date: "2023-10-09", Matches: []Match{upcoming, playedToday}},
+			scheduleSnapshot{Date: "2023-10-09", Matches: []Match{upcoming}},
+			nil},
+		{"dropped from a later date's list",
+			scheduleSnapshot{Date: "2023-10-07", Matches: []Match{yesterday, upcoming}},
+			scheduleSnapshot{Date: "2023-10-09", Matches: []Match{upcoming}},
+			nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, change := range g.diffSchedules(context.Background(), tt.previous, tt.current) {
+			got = append(got, change.Type+" "+change.UID)
+			if (change.Type == scheduleRescheduled || change.Type == scheduleRelocated) && change.Previous == nil {
+				t.Errorf("%s: %s change without the previous match", tt.name, change.Type)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: diffSchedules = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRequestDates(t *testing.T) {
+	tests := []struct {
+		previous string
+		now      string
+		want     []string
+	}{
+		{"2023-10-09", "2023-10-09T12:00:00Z", []string{"2023-10-09", "2023-10-10"}},
+		{"2023-10-09", "2023-10-09T11:00:00Z", []string{"2023-10-08", "2023-10-09", "2023-10-10"}},
+		{"2023-10-09", "2023-10-09T09:00:00Z", []string{"2023-10-08", "2023-10-09"}},
+		{"2023-10-07", "2023-10-09T12:00:00Z", []string{"2023-10-07", "2023-10-09", "2023-10-10"}},
+		{"", "2023-10-09T12:00:00Z", []string{"2023-10-09", "2023-10-10"}},
+	}
+	for _, tt := range tests {
+		now, err := time.Parse(time.RFC3339, tt.now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := requestDates(tt.previous, now); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("requestDates(%q, %s) = %q, want %q", tt.previous, tt.now, got, tt.want)
+		}
+	}
+}
+
+func TestRecordScheduleInvalidatesMovedMatches(t *testing.T) {
+	ctx := context.Background()
+	g := newTestGateway(t, "2023-10-09T12:00:00Z")
+	withVenues(t, g)
+
+	upcoming := Match{UID: "1", Name: "Bruins at Rangers", City: "New York", StartTime: "2023-10-12T23:00Z"}
+	moved := upcoming
+	moved.StartTime = "2023-10-13T23:00Z"
+
+	// Lists of other timezones' dates don't set the baseline
+	g.recordSchedule(ctx, "2023-10-10", []Match{upcoming})
+	if _, err := g.cache.Get(ctx, scheduleSnapshotKey); err != errCacheMiss {
+		t.Fatalf("list of another timezone's date recorded, error %v", err)
+	}
+
+	g.recordSchedule(ctx, "2023-10-09", []Match{upcoming})
+	// East of UTC+12 it is already 2023-10-10
+	cached := []string{
+		"upcoming_matches_2023-10-09", "matches_weather_forecast_2023-10-09", forecastSnapshotPrefix + "1",
+		"today_matches_and_weather_2023-10-10", "weather_alerts_2023-10-10",
+	}
+	for _, key := range cached {
+		g.cache.Set(ctx, key, "{}", 0)
+	}
+	g.recordSchedule(ctx, "2023-10-09", []Match{moved})
+
+	if changes := g.scheduleChanges(ctx); len(changes) != 1 || changes[0].Type != scheduleRescheduled {
+		t.Errorf("schedule changes = %+v, want one rescheduled match", changes)
+	}
+	for _, key := range cached {
+		if _, err := g.cache.Get(ctx, key); err != errCacheMiss {
+			t.Errorf("%s kept, error %v", key, err)
+		}
+	}
+	for _, date := range []string{"2023-10-09", "2023-10-10"} {
+		if _, err := g.cache.Get(ctx, teamScheduleGenerationPrefix+date); err != nil {
+			t.Errorf("team schedule generation of %s not renewed: %v", date, err)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Content types a client can ask for in the Accept header to receive aggregation items as they arrive
+const (
+	ndjsonContentType = "application/x-ndjson"
+	sseContentType    = "text/event-stream"
+)
+
+// StreamItemError is a failed item reported in the summary of a stream
+type StreamItemError struct {
+	UID   string     `json:"uid"`
+	Error *ItemError `json:"error"`
+}
+
+// StreamSummary is the last event of a stream
+type StreamSummary struct {
+	Partial   bool              `json:"partial"`
+	Total     int               `json:"total"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Errors    []StreamItemError `json:"errors"`
+	DayErrors []DayError        `json:"day_errors,omitempty"` // Days of a range whose matches could not be fetched
+}
+
+// aggregationStream writes aggregation items one by one as NDJSON lines or server-sent events,
+// flushing after each of them, and ends with a summary
+type aggregationStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	sse     bool
+	view    responseView
+	started bool
+	summary StreamSummary
+}
+
+// newAggregationStream returns a stream when the client asked for NDJSON or server-sent events, or nil.
+// Nothing is written until the first item, so handlers can still fail with a regular error response.
+func newAggregationStream(w http.ResponseWriter, r *http.Request) *aggregationStream {
+	format := streamFormat(r)
+	if format == "" {
+		return nil
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil
+	}
+	return &aggregationStream{
+		w:       w,
+		flusher: flusher,
+		sse:     format == sseContentType,
+		view:    parseResponseView(r),
+		summary: StreamSummary{Errors: []StreamItemError{}},
+	}
+}
+
+// streamFormat returns the streaming content type named in the Accept header, or "" for a regular response
+func streamFormat(r *http.Request) string {
+	accept := r.Header.Get("Accept")
+	for _, format := range []string{ndjsonContentType, sseContentType} {
+		if strings.Contains(accept, format) {
+			return format
+		}
+	}
+	return ""
+}
+
+// item sends one aggregation item, unless the request's filters leave it out. Filters and fields= are
+// applied here because the streamed body can't be rewritten as a whole.
+func (s *aggregationStream) item(uid string, item interface{}, itemErr *ItemError) {
+	body, err := json.Marshal(item)
+	if err != nil {
+		return
+	}
+	if !s.view.empty() {
+		var object interface{}
+		if err := json.Unmarshal(body, &object); err != nil {
+			return
+		}
+		if len(s.view.filterItems([]interface{}{object})) == 0 {
+			return
+		}
+		if s.view.fields != nil {
+			s.view.project(object)
+		}
+		if body, err = json.Marshal(object); err != nil {
+			return
+		}
+	}
+
+	s.summary.Total++
+	if itemErr != nil {
+		s.summary.Failed++
+		s.summary.Partial = true
+		s.summary.Errors = append(s.summary.Errors, StreamItemError{UID: uid, Error: itemErr})
+	} else {
+		s.summary.Succeeded++
+	}
+	s.send("item", body)
+}
+
+// dayError records a day of a range whose matches could not be fetched; it is reported in the summary
+func (s *aggregationStream) dayError(dayError DayError) {
+	s.summary.Partial = true
+	s.summary.DayErrors = append(s.summary.DayErrors, dayError)
+}
+
+// finish sends the summary event
+func (s *aggregationStream) finish() {
+	body, err := json.Marshal(s.summary)
+	if err != nil {
+		return
+	}
+	s.send("summary", body)
+}
+
+// send writes one event and flushes it to the client
+func (s *aggregationStream) send(event string, data []byte) {
+	if !s.started {
+		s.started = true
+		if s.sse {
+			s.w.Header().Set("Content-Type", sseContentType)
+		} else {
+			s.w.Header().Set("Content-Type", ndjsonContentType)
+		}
+		s.w.Header().Set("Cache-Control", "no-cache")
+		s.w.WriteHeader(http.StatusOK)
+	}
+
+	if s.sse {
+		fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, data)
+	} else {
+		fmt.Fprintf(s.w, "{\"type\":%q,\"data\":%s}\n", event, data)
+	}
+	s.flusher.Flush()
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/afex/hystrix-go/hystrix"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// teamID accepts team IDs sent either as JSON strings or numbers
+type teamID string
+
+func (id *teamID) UnmarshalJSON(data []byte) error {
+	*id = teamID(strings.Trim(string(data), `"`))
+	return nil
+}
+
+// TeamInfo is one team of a game, as returned by the matches microservice's /team_info endpoint
+type TeamInfo struct {
+	TeamID       teamID          `json:"team_id"`
+	Name         string          `json:"name"`
+	Abbreviation string          `json:"abbreviation"`
+	Logo         string          `json:"logo"`
+	Record       json.RawMessage `json:"record,omitempty"`
+}
+
+// TeamInfoResponse holds both teams of a game
+type TeamInfoResponse struct {
+	Team1 *TeamInfo `json:"team1"`
+	Team2 *TeamInfo `json:"team2"`
+}
+
+// find returns the team with the given ID, or nil when neither team has it
+func (t TeamInfoResponse) find(id string) *TeamInfo {
+	for _, team := range []*TeamInfo{t.Team1, t.Team2} {
+		if team != nil && string(team.TeamID) == id {
+			return team
+		}
+	}
+	return nil
+}
+
+// TeamGame is an upcoming game of a team with the forecast at its venue
+type TeamGame struct {
+	WeatherForecastResponseWithInfo
+	Home       bool              `json:"home"`
+	Opponent   string            `json:"opponent"`
+	Teams      *TeamInfoResponse `json:"teams,omitempty"`
+	TeamsError *ItemError        `json:"teams_error,omitempty"`
+}
+
+// TeamScheduleResponse lists a team's upcoming games
+type TeamScheduleResponse struct {
+	Team    string     `json:"team"`
+	Partial bool       `json:"partial"`
+	Games   []TeamGame `json:"games"`
+}
+
+// teamInfo returns both teams of a game, sharing getTeamInfo's cache entries
+func (g *Gateway) teamInfo(ctx context.Context, gameID string) (TeamInfoResponse, error) {
+	hystrix.ConfigureCommand("getTeamInfo", hystrix.CommandConfig{
+		Timeout:               8000, // Timeout in milliseconds
+		MaxConcurrentRequests: 100,  // Maximum number of concurrent requests
+		ErrorPercentThreshold: 25,   // Error percentage threshold for circuit breaker
+	})
+
+	var teams TeamInfoResponse
+	body, err := g.cachedUpstream(ctx, "team_info_"+gameID, "getTeamInfo",
+		MatchesBalancer()+"/team_info?"+url.Values{"game_id": {gameID}}.Encode())
+	if err != nil {
+		return teams, err
+	}
+	err = json.Unmarshal(body, &teams)
+	return teams, err
+}
+
+// upcomingMatches returns the upcoming matches for a date, sharing getUpcomingMatches' cache entries
+func (g *Gateway) upcomingMatches(ctx context.Context, today string) ([]Match, error) {
+	hystrix.ConfigureCommand("getUpcomingMatches", hystrix.CommandConfig{
+		Timeout:               20000, // Timeout in milliseconds
+		MaxConcurrentRequests: 100,   // Maximum number of concurrent requests
+		ErrorPercentThreshold: 25,    // Error percentage threshold for circuit breaker
+	})
+
+	body, fetched, err := g.cachedUpstreamFetched(ctx, "upcoming_matches_"+today, "getUpcomingMatches",
+		MatchesBalancer()+"/upcoming_matches?"+url.Values{"date": {today}}.Encode(), time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []Match
+	if err := json.Unmarshal(body, &matches); err != nil {
+		return nil, err
+	}
+	if fetched {
+		g.recordSchedule(ctx, today, matches)
+	}
+	return matches, nil
+}
+
+// matchSides splits a match name ("Away at Home") into the away and home team names
+func matchSides(match Match) (string, string) {
+	away, home, found := strings.Cut(match.Name, " at ")
+	if !found {
+		return match.Name, ""
+	}
+	return strings.TrimSpace(away), strings.TrimSpace(home)
+}
+
+// getTeamSchedule returns a team's upcoming games with the forecast at each venue.
+// The team is given by name (e.g. "Bruins") or by its NHL team ID.
+func (g *Gateway) getTeamSchedule(w http.ResponseWriter, r *http.Request) {
+	team := strings.TrimSpace(r.URL.Query().Get("team"))
+	if team == "" {
+		http.Error(w, "Team is a required parameter", http.StatusBadRequest)
+		return
+	}
+
+	today, ok := g.requestToday(w, r)
+	if !ok {
+		return
+	}
+
+	// Create a cache key based on the team, today's date and the date's generation
+	cacheKey := "team_schedule_" + strings.ToLower(team) + "_" + today
+	if generation, err := g.cache.Get(r.Context(), teamScheduleGenerationPrefix+today); err == nil {
+		cacheKey += "_" + generation
+	}
+
+	// Check if the result is already in the cache
+	cachedResult, err := g.cache.Get(r.Context(), cacheKey)
+	if err == nil {
+		// If cached result is found, return it
+		w.Write([]byte(cachedResult))
+		return
+	}
+
+	matches, err := g.upcomingMatches(r.Context(), today)
+	if err != nil {
+		http.Error(w, "Error making request to matches_ms for upcoming matches", http.StatusInternalServerError)
+		return
+	}
+
+	// A name can be matched against Match.Name directly, an ID needs the team info of every game
+	byID := strings.Trim(team, "0123456789") == ""
+	var candidates []Match
+	for _, match := range matches {
+		if byID || matchHasTeam(match, team) {
+			candidates = append(candidates, match)
+		}
+	}
+
+	type teamLookup struct {
+		teams TeamInfoResponse
+		err   error
+	}
+	lookups := fanOut(r.Context(), len(candidates), g.aggregationWorkers(), func(ctx context.Context, i int) teamLookup {
+		teams, err := g.teamInfo(ctx, candidates[i].UID)
+		return teamLookup{teams: teams, err: err}
+	})
+
+	response := TeamScheduleResponse{Team: team, Games: []TeamGame{}}
+	var games []TeamGame
+	var gameMatches []Match
+	for i, match := range candidates {
+		game := TeamGame{TeamsError: newItemError("Error making request to matches microservice for team info", lookups[i].err)}
+		if lookups[i].err == nil {
+			game.Teams = &lookups[i].teams
+		}
+
+		name := team
+		if byID {
+			// Without team info there is no way to tell whether the game belongs to the team
+			if game.Teams == nil {
+				response.Partial = true
+				continue
+			}
+			found := game.Teams.find(team)
+			if found == nil {
+				continue
+			}
+			name = found.Name
+		}
+
+		away, home := matchSides(match)
+		game.Home = strings.Contains(strings.ToLower(home), strings.ToLower(name))
+		game.Opponent = home
+		if game.Home {
+			game.Opponent = away
+		}
+
+		games = append(games, game)
+		gameMatches = append(gameMatches, match)
+	}
+
+	forecasts := g.matchForecasts(r.Context(), gameMatches, false)
+	for i := range games {
+		games[i].WeatherForecastResponseWithInfo = forecasts[i]
+		if games[i].Error != nil || games[i].TeamsError != nil {
+			response.Partial = true
+		}
+	}
+	response.Games = append(response.Games, games...)
+
+	responseBody, err := writeAggregation(w, response, response.Partial)
+	if err != nil {
+		http.Error(w, "Error encoding team schedule response", http.StatusInternalServerError)
+		return
+	}
+
+	// Cache the result with an expiration time, unless some games are missing
+	if !response.Partial {
+		g.cache.Set(context.Background(), cacheKey, string(responseBody), time.Hour)
+	}
+}
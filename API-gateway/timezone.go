@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+	_ "time/tzdata" // The alpine image has no zoneinfo, so the timezone database is compiled in
+)
+
+// dateKeyLayout is the YYYY-MM-DD format of "today" in cache keys and matches microservice calls
+const dateKeyLayout = "2006-01-02"
+
+// requestLocation returns the timezone from the request's tz parameter, or the configured default
+func (g *Gateway) requestLocation(r *http.Request) (*time.Location, error) {
+	name := r.URL.Query().Get("tz")
+	if name == "" {
+		name = g.config.DefaultTimezone
+	}
+	return time.LoadLocation(name)
+}
+
+// defaultToday resolves today's date in the default timezone, for work that runs outside a request
+func (g *Gateway) defaultToday(ctx context.Context) (string, error) {
+	location, err := time.LoadLocation(g.config.DefaultTimezone)
+	if err != nil {
+		return "", err
+	}
+	return g.now(ctx).In(location).Format(dateKeyLayout), nil
+}
+
+// requestToday resolves today's date in the request's timezone. When the tz parameter is invalid it writes
+// a 400 response and returns false.
+func (g *Gateway) requestToday(w http.ResponseWriter, r *http.Request) (string, bool) {
+	location, err := g.requestLocation(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid timezone: %v", err), http.StatusBadRequest)
+		return "", false
+	}
+	return g.now(r.Context()).In(location).Format(dateKeyLayout), true
+}
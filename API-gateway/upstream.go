@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/afex/hystrix-go/hystrix"
+	"io"
+	"net/http"
+	"time"
+)
+
+// upstreamResult is a microservice response handed from the Hystrix goroutine back to the handler
+type upstreamResult struct {
+	status int
+	body   []byte
+}
+
+// callUpstream makes a GET request to a microservice under the given Hystrix command.
+// The request is bound to ctx (normally the incoming request's context), and it is cancelled as soon as
+// callUpstream returns, so a client disconnect or a Hystrix timeout stops the in-flight call.
+// The response is passed back through a channel, so the Hystrix goroutine never writes to the caller's variables.
+func callUpstream(ctx context.Context, command string, rawURL string) (int, []byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan upstreamResult, 1)
+	err := hystrix.DoC(ctx, command, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+
+		results <- upstreamResult{status: resp.StatusCode, body: body}
+		return nil
+	}, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	result := <-results
+	return result.status, result.body, nil
+}
+
+// cachedUpstream returns a microservice response from the cache, or fetches it and caches it for an hour.
+// Responses other than 200 OK are returned as errors and not cached.
+func (g *Gateway) cachedUpstream(ctx context.Context, cacheKey string, command string, rawURL string) ([]byte, error) {
+	return g.cachedUpstreamFor(ctx, cacheKey, command, rawURL, time.Hour)
+}
+
+// cachedUpstreamFor works like cachedUpstream, caching the response for ttl
+func (g *Gateway) cachedUpstreamFor(ctx context.Context, cacheKey string, command string, rawURL string, ttl time.Duration) ([]byte, error) {
+	body, _, err := g.cachedUpstreamFetched(ctx, cacheKey, command, rawURL, ttl)
+	return body, err
+}
+
+// cachedUpstreamFetched works like cachedUpstreamFor, and also reports whether the response was fetched from
+// the microservice rather than read from the cache
+func (g *Gateway) cachedUpstreamFetched(ctx context.Context, cacheKey string, command string, rawURL string, ttl time.Duration) ([]byte, bool, error) {
+	if cachedResult, err := g.cache.Get(ctx, cacheKey); err == nil {
+		return []byte(cachedResult), false, nil
+	}
+
+	status, body, err := callUpstream(ctx, command, rawURL)
+	if err != nil {
+		return nil, false, err
+	}
+	if status != http.StatusOK {
+		return nil, false, fmt.Errorf("%s returned status %d", command, status)
+	}
+
+	g.cache.Set(context.Background(), cacheKey, string(body), ttl)
+	return body, true, nil
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Venue describes a place where matches are played
+type Venue struct {
+	Name      string  `json:"name"`
+	City      string  `json:"city"`
+	Region    string  `json:"region"`
+	Country   string  `json:"country"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Timezone  string  `json:"timezone"`
+	Capacity  int     `json:"capacity"`
+	Indoor    bool    `json:"indoor"`
+	// WeatherAffects is "play" for outdoor venues and "travel" for indoor ones, where only fans are affected
+	WeatherAffects string `json:"weather_affects"`
+}
+
+// hasCoordinates reports whether the venue can be located by latitude and longitude
+func (v Venue) hasCoordinates() bool {
+	return v.Latitude != 0 || v.Longitude != 0
+}
+
+// query returns the weather query for the venue, preferring its coordinates over its city
+func (v Venue) query() string {
+	if v.hasCoordinates() {
+		return fmt.Sprintf("%.4f,%.4f", v.Latitude, v.Longitude)
+	}
+	if v.Region == "" {
+		return locationQuery(v.City)
+	}
+	return locationQuery(v.City + "," + v.Region)
+}
+
+// weatherAffects returns what the weather at a venue affects
+func weatherAffects(indoor bool) string {
+	if indoor {
+		return "travel"
+	}
+	return "play"
+}
+
+// builtinVenues seeds the registry with the NHL arenas. Outdoor events (Winter Classic, Stadium Series)
+// are added through the admin API when they are scheduled.
+var builtinVenues = []Venue{
+	{Name: "TD Garden", City: "Boston", Region: "Massachusetts", Country: "USA", Latitude: 42.3662, Longitude: -71.0621, Timezone: "America/New_York", Capacity: 17565, Indoor: true},
+	{Name: "KeyBank Center", City: "Buffalo", Region: "New York", Country: "USA", Latitude: 42.8750, Longitude: -78.8764, Timezone: "America/New_York", Capacity: 19070, Indoor: true},
+	{Name: "Little Caesars Arena", City: "Detroit", Region: "Michigan", Country: "USA", Latitude: 42.3411, Longitude: -83.0553, Timezone: "America/Detroit", Capacity: 19515, Indoor: true},
+	{Name: "Amerant Bank Arena", City: "Sunrise", Region: "Florida", Country: "USA", Latitude: 26.1584, Longitude: -80.3256, Timezone: "America/New_York", Capacity: 19250, Indoor: true},
+	{Name: "Bell Centre", City: "Montreal", Region: "Quebec", Country: "Canada", Latitude: 45.4961, Longitude: -73.5693, Timezone: "America/Toronto", Capacity: 21105, Indoor: true},
+	{Name: "Canadian Tire Centre", City: "Ottawa", Region: "Ontario", Country: "Canada", Latitude: 45.2969, Longitude: -75.9272, Timezone: "America/Toronto", Capacity: 18652, Indoor: true},
+	{Name: "Amalie Arena", City: "Tampa", Region: "Florida", Country: "USA", Latitude: 27.9427, Longitude: -82.4519, Timezone: "America/New_York", Capacity: 19092, Indoor: true},
+	{Name: "Scotiabank Arena", City: "Toronto", Region: "Ontario", Country: "Canada", Latitude: 43.6435, Longitude: -79.3791, Timezone: "America/Toronto", Capacity: 18800, Indoor: true},
+	{Name: "PNC Arena", City: "Raleigh", Region: "North Carolina", Country: "USA", Latitude: 35.8033, Longitude: -78.7219, Timezone: "America/New_York", Capacity: 18680, Indoor: true},
+	{Name: "Nationwide Arena", City: "Columbus", Region: "Ohio", Country: "USA", Latitude: 39.9692, Longitude: -83.0061, Timezone: "America/New_York", Capacity: 18144, Indoor: true},
+	{Name: "Prudential Center", City: "Newark", Region: "New Jersey", Country: "USA", Latitude: 40.7334, Longitude: -74.1711, Timezone: "America/New_York", Capacity: 16514, Indoor: true},
+	{Name: "UBS Arena", City: "Elmont", Region: "New York", Country: "USA", Latitude: 40.7110, Longitude: -73.7260, Timezone: "America/New_York", Capacity: 17255, Indoor: true},
+	{Name: "Madison Square Garden", City: "New York", Region: "New York", Country: "USA", Latitude: 40.7505, Longitude: -73.9934, Timezone: "America/New_York", Capacity: 18006, Indoor: true},
+	{Name: "Wells Fargo Center", City: "Philadelphia", Region: "Pennsylvania", Country: "USA", Latitude: 39.9012, Longitude: -75.1720, Timezone: "America/New_York", Capacity: 19537, Indoor: true},
+	{Name: "PPG Paints Arena", City: "Pittsburgh", Region: "Pennsylvania", Country: "USA", Latitude: 40.4394, Longitude: -79.9892, Timezone: "America/New_York", Capacity: 18387, Indoor: true},
+	{Name: "Capital One Arena", City: "Washington", Region: "District of Columbia", Country: "USA", Latitude: 38.8981, Longitude: -77.0209, Timezone: "America/New_York", Capacity: 18573, Indoor: true},
+	{Name: "Mullett Arena", City: "Tempe", Region: "Arizona", Country: "USA", Latitude: 33.4265, Longitude: -111.9318, Timezone: "America/Phoenix", Capacity: 4600, Indoor: true},
+	{Name: "United Center", City: "Chicago", Region: "Illinois", Country: "USA", Latitude: 41.8807, Longitude: -87.6742, Timezone: "America/Chicago", Capacity: 19717, Indoor: true},
+	{Name: "Ball Arena", City: "Denver", Region: "Colorado", Country: "USA", Latitude: 39.7487, Longitude: -105.0077, Timezone: "America/Denver", Capacity: 18007, Indoor: true},
+	{Name: "American Airlines Center", City: "Dallas", Region: "Texas", Country: "USA", Latitude: 32.7905, Longitude: -96.8103, Timezone: "America/Chicago", Capacity: 18532, Indoor: true},
+	{Name: "Xcel Energy Center", City: "Saint Paul", Region: "Minnesota", Country: "USA", Latitude: 44.9448, Longitude: -93.1010, Timezone: "America/Chicago", Capacity: 17954, Indoor: true},
+	{Name: "Bridgestone Arena", City: "Nashville", Region: "Tennessee", Country: "USA", Latitude: 36.1592, Longitude: -86.7785, Timezone: "America/Chicago", Capacity: 17159, Indoor: true},
+	{Name: "Enterprise Center", City: "Saint Louis", Region: "Missouri", Country: "USA", Latitude: 38.6268, Longitude: -90.2027, Timezone: "America/Chicago", Capacity: 18096, Indoor: true},
+	{Name: "Canada Life Centre", City: "Winnipeg", Region: "Manitoba", Country: "Canada", Latitude: 49.8927, Longitude: -97.1437, Timezone: "America/Winnipeg", Capacity: 15321, Indoor: true},
+	{Name: "Honda Center", City: "Anaheim", Region: "California", Country: "USA", Latitude: 33.8078, Longitude: -117.8765, Timezone: "America/Los_Angeles", Capacity: 17174, Indoor: true},
+	{Name: "Scotiabank Saddledome", City: "Calgary", Region: "Alberta", Country: "Canada", Latitude: 51.0374, Longitude: -114.0519, Timezone: "America/Edmonton", Capacity: 19289, Indoor: true},
+	{Name: "Rogers Place", City: "Edmonton", Region: "Alberta", Country: "Canada", Latitude: 53.5469, Longitude: -113.4979, Timezone: "America/Edmonton", Capacity: 18347, Indoor: true},
+	{Name: "Crypto.com Arena", City: "Los Angeles", Region: "California", Country: "USA", Latitude: 34.0430, Longitude: -118.2673, Timezone: "America/Los_Angeles", Capacity: 18230, Indoor: true},
+	{Name: "SAP Center at San Jose", City: "San Jose", Region: "California", Country: "USA", Latitude: 37.3327, Longitude: -121.9012, Timezone: "America/Los_Angeles", Capacity: 17562, Indoor: true},
+	{Name: "Climate Pledge Arena", City: "Seattle", Region: "Washington", Country: "USA", Latitude: 47.6221, Longitude: -122.3540, Timezone: "America/Los_Angeles", Capacity: 17100, Indoor: true},
+	{Name: "Rogers Arena", City: "Vancouver", Region: "British Columbia", Country: "Canada", Latitude: 49.2778, Longitude: -123.1089, Timezone: "America/Vancouver", Capacity: 18910, Indoor: true},
+	{Name: "T-Mobile Arena", City: "Las Vegas", Region: "Nevada", Country: "USA", Latitude: 36.1029, Longitude: -115.1785, Timezone: "America/Los_Angeles", Capacity: 17500, Indoor: true},
+}
+
+// venueRegistryKey is the cache key holding the registry, shared by all gateway replicas
+const venueRegistryKey = "venue_registry"
+
+// venueRegistryRefresh is how long a gateway uses its copy of the registry before reading it again
+const venueRegistryRefresh = time.Minute
+
+// VenueRegistry keeps the venues keyed by VenueFullName. It is persisted in the cache without expiration,
+// so edits made through one gateway are picked up by the others.
+type VenueRegistry struct {
+	cache Cache
+
+	mu       sync.Mutex
+	venues   map[string]Venue
+	loadedAt time.Time
+}
+
+func newVenueRegistry(cache Cache) *VenueRegistry {
+	return &VenueRegistry{cache: cache}
+}
+
+// snapshot returns the current venues, reading them from the cache when the local copy is stale.
+// The caller must hold the lock.
+func (vr *VenueRegistry) snapshot(ctx context.Context) map[string]Venue {
+	if vr.venues != nil && time.Since(vr.loadedAt) < venueRegistryRefresh {
+		return vr.venues
+	}
+
+	venues := make(map[string]Venue)
+	stored, err := vr.cache.Get(ctx, venueRegistryKey)
+	if err == nil && json.Unmarshal([]byte(stored), &venues) == nil {
+		vr.venues = venues
+	} else if vr.venues == nil {
+		// Nothing stored yet (or the cache is unavailable): start from the built-in venues
+		for _, venue := range builtinVenues {
+			venue.WeatherAffects = weatherAffects(venue.Indoor)
+			venues[venue.Name] = venue
+		}
+		vr.venues = venues
+	}
+	vr.loadedAt = time.Now()
+	return vr.venues
+}
+
+// save writes the venues to the cache. The caller must hold the lock.
+func (vr *VenueRegistry) save(ctx context.Context, venues map[string]Venue) error {
+	body, err := json.Marshal(venues)
+	if err != nil {
+		return err
+	}
+	if err := vr.cache.Set(ctx, venueRegistryKey, string(body), 0); err != nil {
+		return err
+	}
+	vr.venues = venues
+	vr.loadedAt = time.Now()
+	return nil
+}
+
+// Get returns the venue with the given name
+func (vr *VenueRegistry) Get(ctx context.Context, name string) (Venue, bool) {
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+
+	venue, ok := vr.snapshot(ctx)[name]
+	return venue, ok
+}
+
+// FindByCity returns a venue in the given city, matched case-insensitively. When several venues share the city,
+// a built-in venue wins over added ones, then the first by name, so the same venue is always returned.
+func (vr *VenueRegistry) FindByCity(ctx context.Context, city string) (Venue, bool) {
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+
+	var found Venue
+	ok := false
+	for _, venue := range vr.snapshot(ctx) {
+		if !strings.EqualFold(venue.City, city) {
+			continue
+		}
+		if !ok || venueBefore(venue, found) {
+			found, ok = venue, true
+		}
+	}
+	return found, ok
+}
+
+// venueBefore reports whether FindByCity prefers venue a over venue b
+func venueBefore(a, b Venue) bool {
+	if builtinA, builtinB := isBuiltinVenue(a.Name), isBuiltinVenue(b.Name); builtinA != builtinB {
+		return builtinA
+	}
+	return a.Name < b.Name
+}
+
+// isBuiltinVenue reports whether a venue name is one of the built-in venues
+func isBuiltinVenue(name string) bool {
+	for _, venue := range builtinVenues {
+		if venue.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// List returns all venues sorted by name
+func (vr *VenueRegistry) List(ctx context.Context) []Venue {
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+
+	var venues []Venue
+	for _, venue := range vr.snapshot(ctx) {
+		venues = append(venues, venue)
+	}
+	sort.Slice(venues, func(i, j int) bool { return venues[i].Name < venues[j].Name })
+	return venues
+}
+
+// Put adds or replaces a venue
+func (vr *VenueRegistry) Put(ctx context.Context, venue Venue) (Venue, error) {
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+
+	venue.WeatherAffects = weatherAffects(venue.Indoor)
+
+	venues := make(map[string]Venue)
+	for name, existing := range vr.snapshot(ctx) {
+		venues[name] = existing
+	}
+	venues[venue.Name] = venue
+	return venue, vr.save(ctx, venues)
+}
+
+// Delete removes a venue and reports whether it existed
+func (vr *VenueRegistry) Delete(ctx context.Context, name string) (bool, error) {
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+
+	venues := make(map[string]Venue)
+	for existingName, existing := range vr.snapshot(ctx) {
+		venues[existingName] = existing
+	}
+	if _, ok := venues[name]; !ok {
+		return false, nil
+	}
+	delete(venues, name)
+	return true, vr.save(ctx, venues)
+}
+
+// matchVenue returns the registered venue of a match, or nil when the venue is unknown
+func (g *Gateway) matchVenue(ctx context.Context, match Match) *Venue {
+	venue, ok := g.venues.Get(ctx, match.VenueFullName)
+	if !ok {
+		return nil
+	}
+	return &venue
+}
+
+// requireAdmin checks the admin token and writes an error response when the request is not allowed
+func (g *Gateway) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if g.config.AdminToken == "" {
+		http.Error(w, "Admin API is disabled", http.StatusForbidden)
+		return false
+	}
+	if r.Header.Get("X-Admin-Token") != g.config.AdminToken {
+		http.Error(w, "Invalid admin token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// venuesHandler serves the admin API of the venue registry:
+// GET /admin/venues, and GET, PUT or DELETE /admin/venues/{name}
+func (g *Gateway) venuesHandler(w http.ResponseWriter, r *http.Request) {
+	if !g.requireAdmin(w, r) {
+		return
+	}
+
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/venues"), "/")
+
+	switch {
+	case name == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, g.venues.List(r.Context()))
+
+	case name != "" && r.Method == http.MethodGet:
+		venue, ok := g.venues.Get(r.Context(), name)
+		if !ok {
+			http.Error(w, "Venue not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, venue)
+
+	case name != "" && r.Method == http.MethodPut:
+		var venue Venue
+		if err := json.NewDecoder(r.Body).Decode(&venue); err != nil {
+			http.Error(w, "Error parsing venue", http.StatusBadRequest)
+			return
+		}
+		venue.Name = name
+		if venue.City == "" && !venue.hasCoordinates() {
+			http.Error(w, "A venue needs a city or coordinates", http.StatusBadRequest)
+			return
+		}
+		if venue.Timezone != "" {
+			if _, err := time.LoadLocation(venue.Timezone); err != nil {
+				http.Error(w, "Unknown timezone", http.StatusBadRequest)
+				return
+			}
+		}
+
+		venue, err := g.venues.Put(r.Context(), venue)
+		if err != nil {
+			http.Error(w, "Error saving venue", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, venue)
+
+	case name != "" && r.Method == http.MethodDelete:
+		deleted, err := g.venues.Delete(r.Context(), name)
+		if err != nil {
+			http.Error(w, "Error deleting venue", http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, "Venue not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// writeJSON encodes a response as JSON with the given status code
+func writeJSON(w http.ResponseWriter, status int, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
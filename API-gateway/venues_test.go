@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFindByCity(t *testing.T) {
+	ctx := context.Background()
+	g := newTestGateway(t, "2023-10-09T12:00:00Z")
+	// The registry starts from the built-in venues; these share their cities with one or with each other
+	for _, venue := range []Venue{
+		{Name: "Barclays Center", City: "New York", Timezone: "America/New_York"},
+		{Name: "Apollo Theater", City: "New York", Timezone: "America/New_York"},
+		{Name: "Rose Garden", City: "Portland", Timezone: "America/Los_Angeles"},
+		{Name: "Moda Center", City: "Portland", Timezone: "America/Los_Angeles"},
+	} {
+		if _, err := g.venues.Put(ctx, venue); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		city string
+		want string
+	}{
+		{"New York", "Madison Square Garden"},
+		{"new york", "Madison Square Garden"},
+		{"Portland", "Moda Center"},
+		{"Boston", "TD Garden"},
+	}
+	for _, tt := range tests {
+		// Map order changes from one iteration to the next, so repeat the lookup
+		for i := 0; i < 20; i++ {
+			venue, ok := g.venues.FindByCity(ctx, tt.city)
+			if !ok || venue.Name != tt.want {
+				t.Fatalf("FindByCity(%q) = %q, %v; want %q", tt.city, venue.Name, ok, tt.want)
+			}
+		}
+	}
+	if venue, ok := g.venues.FindByCity(ctx, "Nowhere"); ok {
+		t.Errorf("FindByCity(Nowhere) = %q, want no venue", venue.Name)
+	}
+}
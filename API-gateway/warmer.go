@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"time"
+)
+
+// warmerLockKey is the Redis key holding the ID of the gateway that currently warms the cache
+const warmerLockKey = "cache_warmer_leader"
+
+// instanceID identifies this gateway instance in leader elections
+var instanceID = func() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}()
+
+// startCacheWarmer schedules cache warming according to the configured cron expression
+func (g *Gateway) startCacheWarmer() {
+	if g.config.WarmSchedule == "" {
+		return
+	}
+
+	// Runs fire on the wall clock, but the dates they warm come from the gateway clock, so a pinned
+	// GATEWAY_NOW warms the pinned day
+	scheduler := cron.New()
+	_, err := scheduler.AddFunc(g.config.WarmSchedule, func() {
+		if !g.acquireWarmerLock(context.Background()) {
+			return
+		}
+		g.warmCache()
+	})
+	if err != nil {
+		fmt.Println("Error scheduling cache warming:", err)
+		return
+	}
+
+	scheduler.Start()
+	fmt.Println("Cache warming scheduled:", g.config.WarmSchedule)
+}
+
+// acquireWarmerLock makes this gateway the warming leader, or renews its leadership.
+// It returns false when another gateway holds the lock.
+func (g *Gateway) acquireWarmerLock(ctx context.Context) bool {
+	return g.acquireLeaderLock(ctx, warmerLockKey, g.config.WarmLockTTL)
+}
+
+// acquireLeaderLock makes this gateway the leader of a background job, or renews its leadership.
+// It returns false when another gateway holds the lock.
+func (g *Gateway) acquireLeaderLock(ctx context.Context, key string, ttl time.Duration) bool {
+	locker, ok := g.cache.(Locker)
+	if !ok {
+		fmt.Println("Cache backend does not support the leader lock", key)
+		return false
+	}
+
+	acquired, err := locker.SetNX(ctx, key, instanceID, ttl)
+	if err != nil {
+		fmt.Println("Error acquiring leader lock", key+":", err)
+		return false
+	}
+	if acquired {
+		return true
+	}
+
+	// Only extend a lock this gateway still holds; checking and extending in two steps would let
+	// another gateway take it in between
+	renewed, err := locker.Renew(ctx, key, instanceID, ttl)
+	if err != nil {
+		fmt.Println("Error renewing leader lock", key+":", err)
+		return false
+	}
+	return renewed
+}
+
+// warmCache pre-fetches upcoming matches, today's matches and the forecast for every venue.
+// Each request goes through its regular handler, so the results are written with the normal cache keys.
+// Only the key a route is warming skips the cache; everything it reads on the way (the venue registry,
+// the forecasts warmed before the aggregations) comes from the cache as usual.
+func (g *Gateway) warmCache() {
+	ctx := context.Background()
+	today, err := g.defaultToday(ctx)
+	if err != nil {
+		fmt.Println("Error loading the default timezone for cache warming:", err)
+		return
+	}
+
+	upcomingBody, ok := warmRoute(withCacheRefresh(ctx, "upcoming_matches_"+today), g.getUpcomingMatches, "/matches/upcoming_matches", nil)
+	warmRoute(withCacheRefresh(ctx, "today_matches_"+today), g.getTodayMatches, "/matches/get_today_matches", nil)
+
+	if ok {
+		var matches []Match
+		if err := json.Unmarshal(upcomingBody, &matches); err != nil {
+			fmt.Println("Error parsing upcoming matches while warming the cache:", err)
+		}
+
+		// Warm each venue forecast once, even if several matches share a city and date
+		warmed := make(map[string]bool)
+		for _, match := range matches {
+			if match.City == "" {
+				continue
+			}
+			location := g.resolveLocation(ctx, match)
+			start := g.matchFaceoff(ctx, match)
+			date := start.date()
+			// Matches beyond the forecast horizon get a climatological estimate when the aggregation is warmed
+			if warmed[location+"_"+date] || g.beyondForecastHorizon(ctx, start) {
+				continue
+			}
+			warmed[location+"_"+date] = true
+
+			warmRoute(withCacheRefresh(ctx, location+"_"+date), g.getWeatherRequest, "/weather/forward_weather_forecast", url.Values{
+				"location": {location},
+				"date":     {date},
+			})
+		}
+	}
+
+	warmRoute(withCacheRefresh(ctx, "matches_weather_forecast_"+today), g.getMatchesWeatherForecast, "/meteo_for_future_matches", nil)
+	warmRoute(withCacheRefresh(ctx, "today_matches_and_weather_"+today), g.getTodayMatchesAndWeather, "/meteo_for_today_matches", nil)
+}
+
+// warmRoute runs a handler for a synthetic request and reports whether it succeeded
+func warmRoute(ctx context.Context, handler http.HandlerFunc, path string, params url.Values) ([]byte, bool) {
+	target := path
+	if len(params) > 0 {
+		target += "?" + params.Encode()
+	}
+
+	req := httptest.NewRequest(http.MethodGet, target, nil).WithContext(ctx)
+	recorder := httptest.NewRecorder()
+	handler(recorder, req)
+
+	if recorder.Code != http.StatusOK {
+		fmt.Printf("Error warming cache for %s: status %d\n", target, recorder.Code)
+		return nil, false
+	}
+	return recorder.Body.Bytes(), true
+}
@@ -0,0 +1,740 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Cache keys of the webhook subscriptions, their per-match state, the pending and failed deliveries
+// and the lock of the worker that checks the subscriptions
+const (
+	webhookSubscriptionPrefix  = "webhook_subscription_"
+	webhookSubscriptionIDsKey  = "webhook_subscription_ids"
+	webhookStatePrefix         = "webhook_state_"
+	webhookDeliveryPrefix      = "webhook_delivery_"
+	webhookDeliveryLeasePrefix = "webhook_delivery_lease_"
+	webhookDeliveriesKey       = "webhook_deliveries"
+	webhookDeadLettersKey      = "webhook_dead_letters"
+	webhookWorkerLockKey       = "webhook_worker_leader"
+)
+
+// webhookStateTTL keeps the last forecast sent for a match, and a pending delivery, a bit longer
+// than matches stay upcoming
+const webhookStateTTL = 14 * 24 * time.Hour
+
+// Every gateway looks for due deliveries every webhookPollInterval. The gateway sending one holds its lease,
+// which outlasts the delivery timeout, so no other gateway sends it at the same time.
+const (
+	webhookPollInterval     = time.Second
+	webhookDeliveryLeaseTTL = 30 * time.Second
+)
+
+// A gateway sends at most webhookDeliveryWorkers deliveries at once, and a subscriber gets webhookDeliveryTimeout
+// to answer one, so a slow subscriber doesn't hold back the deliveries to the others
+const (
+	webhookDeliveryWorkers = 8
+	webhookDeliveryTimeout = 10 * time.Second
+)
+
+// maxDeadLetters caps the dead-letter list; the oldest entries are dropped first
+const maxDeadLetters = 200
+
+// Changes between two faceoff summaries that are worth a notification
+const (
+	materialTempChangeC       = 3.0
+	materialWindChangeMPH     = 10.0
+	materialRainChancePercent = 20
+)
+
+// webhookClient sends deliveries; every delivery has its own timeout, webhookDeliveryTimeout
+var webhookClient = &http.Client{}
+
+// WebhookSubscription is a URL that is sent forecast changes and alerts for the matches it selects.
+// Empty filters select every match.
+type WebhookSubscription struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	Secret      string    `json:"secret,omitempty"`
+	Teams       []string  `json:"teams,omitempty"`
+	Cities      []string  `json:"cities,omitempty"`
+	MinSeverity string    `json:"min_severity,omitempty"` // Lowest alert severity sent, "info" by default
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// selects reports whether a match passes the subscription's team and city filters
+func (s WebhookSubscription) selects(match Match) bool {
+	if len(s.Teams) > 0 {
+		found := false
+		for _, team := range s.Teams {
+			if matchHasTeam(match, team) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(s.Cities) > 0 {
+		for _, city := range s.Cities {
+			if strings.EqualFold(normalizeCity(match.City), normalizeCity(city)) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// WebhookEvent is the JSON body POSTed to subscribers
+type WebhookEvent struct {
+	ID             string          `json:"id"`
+	Type           string          `json:"type"` // "forecast_changed" or "alert"
+	SubscriptionID string          `json:"subscription_id"`
+	Match          Match           `json:"match"`
+	Previous       *FaceoffWeather `json:"previous,omitempty"`
+	Current        *FaceoffWeather `json:"current,omitempty"`
+	Alert          *WeatherAlert   `json:"alert,omitempty"`
+	SentAt         time.Time       `json:"sent_at"`
+}
+
+// DeadLetter is a delivery that failed after all retries
+type DeadLetter struct {
+	Event     WebhookEvent `json:"event"`
+	URL       string       `json:"url"`
+	Attempts  int          `json:"attempts"`
+	LastError string       `json:"last_error"`
+	FailedAt  time.Time    `json:"failed_at"`
+}
+
+// webhookDelivery is an event waiting to be sent. It stays in the cache until it was delivered
+// or went to the dead-letter list, so retries survive a gateway restart.
+type webhookDelivery struct {
+	Event         WebhookEvent `json:"event"`
+	Attempts      int          `json:"attempts"`
+	NextAttemptAt time.Time    `json:"next_attempt_at"`
+	LastError     string       `json:"last_error,omitempty"`
+}
+
+// webhookMatchState is what a subscriber was last told about a match
+type webhookMatchState struct {
+	AtFaceoff *FaceoffWeather `json:"at_faceoff"`
+	Alerts    []string        `json:"alerts"` // Rules already sent
+}
+
+// newRandomID returns a random identifier for webhook subscriptions, events and jobs
+func newRandomID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// signWebhook returns the hex HMAC-SHA256 of a body with the subscription's secret
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookSecretCipher derives the AES-256-GCM cipher of the subscription secrets from WEBHOOK_SECRET_KEY
+func (g *Gateway) webhookSecretCipher() (cipher.AEAD, error) {
+	if g.config.WebhookSecretKey == "" {
+		return nil, fmt.Errorf("WEBHOOK_SECRET_KEY is not set")
+	}
+	key := sha256.Sum256([]byte(g.config.WebhookSecretKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealWebhookSecret encrypts a subscription secret before it is stored
+func (g *Gateway) sealWebhookSecret(secret string) (string, error) {
+	aead, err := g.webhookSecretCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(secret), nil)), nil
+}
+
+// openWebhookSecret decrypts a secret sealed by sealWebhookSecret
+func (g *Gateway) openWebhookSecret(sealed string) (string, error) {
+	aead, err := g.webhookSecretCipher()
+	if err != nil {
+		return "", err
+	}
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	if len(raw) < aead.NonceSize() {
+		return "", fmt.Errorf("sealed webhook secret is too short")
+	}
+	secret, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], nil)
+	return string(secret), err
+}
+
+// webhookSubscriptions returns all subscriptions keyed by ID. Every subscription has its own key and
+// their IDs are kept in a list, so gateways adding and removing subscriptions at once don't overwrite each other.
+func (g *Gateway) webhookSubscriptions(ctx context.Context) (map[string]WebhookSubscription, error) {
+	subscriptions := make(map[string]WebhookSubscription)
+	queue := g.cacheQueue()
+	if queue == nil {
+		return subscriptions, nil
+	}
+	ids, err := queue.Range(ctx, webhookSubscriptionIDsKey)
+	if err != nil || len(ids) == 0 {
+		return subscriptions, err
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = webhookSubscriptionPrefix + id
+	}
+	stored, err := g.cache.MGet(ctx, keys...)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range stored {
+		var subscription WebhookSubscription
+		if entry != "" && json.Unmarshal([]byte(entry), &subscription) == nil {
+			subscriptions[subscription.ID] = subscription
+		}
+	}
+	return subscriptions, nil
+}
+
+// webhookSubscription returns one subscription, or errCacheMiss when there is none with this ID
+func (g *Gateway) webhookSubscription(ctx context.Context, id string) (WebhookSubscription, error) {
+	var subscription WebhookSubscription
+	stored, err := g.cache.Get(ctx, webhookSubscriptionPrefix+id)
+	if err != nil {
+		return subscription, err
+	}
+	err = json.Unmarshal([]byte(stored), &subscription)
+	return subscription, err
+}
+
+// deadLetters returns the failed deliveries, oldest first
+func (g *Gateway) deadLetters(ctx context.Context, queue Queue) []DeadLetter {
+	letters := []DeadLetter{}
+	stored, err := queue.Range(ctx, webhookDeadLettersKey)
+	if err != nil {
+		return letters
+	}
+	for _, entry := range stored {
+		var letter DeadLetter
+		if json.Unmarshal([]byte(entry), &letter) == nil {
+			letters = append(letters, letter)
+		}
+	}
+	return letters
+}
+
+// addDeadLetter appends a failed delivery to the dead-letter list and drops the oldest ones beyond the cap
+func (g *Gateway) addDeadLetter(ctx context.Context, queue Queue, letter DeadLetter) {
+	body, err := json.Marshal(letter)
+	if err != nil {
+		return
+	}
+	if err := queue.Push(ctx, webhookDeadLettersKey, string(body)); err != nil {
+		fmt.Println("Error saving webhook dead letter:", err)
+		return
+	}
+	if stored, err := queue.Range(ctx, webhookDeadLettersKey); err == nil {
+		for i := maxDeadLetters; i < len(stored); i++ {
+			queue.Pop(ctx, webhookDeadLettersKey)
+		}
+	}
+}
+
+// webhooksHandler serves the subscription API:
+// GET/POST /admin/webhooks, GET/DELETE /admin/webhooks/{id} and GET /admin/webhooks/dead_letters
+func (g *Gateway) webhooksHandler(w http.ResponseWriter, r *http.Request) {
+	if !g.requireAdmin(w, r) {
+		return
+	}
+
+	queue := g.cacheQueue()
+	if queue == nil {
+		http.Error(w, "Webhooks need the redis or memory cache backend", http.StatusServiceUnavailable)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/webhooks"), "/")
+
+	switch {
+	case id == "dead_letters" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, g.deadLetters(r.Context(), queue))
+
+	case id == "" && r.Method == http.MethodGet:
+		subscriptions, err := g.webhookSubscriptions(r.Context())
+		if err != nil {
+			http.Error(w, "Error reading webhook subscriptions", http.StatusInternalServerError)
+			return
+		}
+		list := []WebhookSubscription{}
+		for _, subscription := range subscriptions {
+			subscription.Secret = ""
+			list = append(list, subscription)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+		writeJSON(w, http.StatusOK, list)
+
+	case id == "" && r.Method == http.MethodPost:
+		g.createWebhookSubscription(w, r, queue)
+
+	case id != "" && r.Method == http.MethodGet:
+		subscription, err := g.webhookSubscription(r.Context(), id)
+		if err == errCacheMiss {
+			http.Error(w, "Webhook subscription not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Error reading webhook subscription", http.StatusInternalServerError)
+			return
+		}
+		subscription.Secret = ""
+		writeJSON(w, http.StatusOK, subscription)
+
+	case id != "" && r.Method == http.MethodDelete:
+		// Only the request that takes the ID off the list deletes the subscription
+		removed, err := queue.Remove(r.Context(), webhookSubscriptionIDsKey, id)
+		if err != nil {
+			http.Error(w, "Error deleting webhook subscription", http.StatusInternalServerError)
+			return
+		}
+		if !removed {
+			http.Error(w, "Webhook subscription not found", http.StatusNotFound)
+			return
+		}
+		g.cache.Delete(r.Context(), webhookSubscriptionPrefix+id, webhookStatePrefix+id)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// createWebhookSubscription validates a subscription, stores it with its secret encrypted and adds it to the list
+func (g *Gateway) createWebhookSubscription(w http.ResponseWriter, r *http.Request, queue Queue) {
+	if g.config.WebhookSecretKey == "" {
+		http.Error(w, "Webhook subscriptions need WEBHOOK_SECRET_KEY to encrypt their secrets", http.StatusServiceUnavailable)
+		return
+	}
+
+	var subscription WebhookSubscription
+	if err := json.NewDecoder(r.Body).Decode(&subscription); err != nil {
+		http.Error(w, "Error parsing webhook subscription", http.StatusBadRequest)
+		return
+	}
+	if !strings.HasPrefix(subscription.URL, "http://") && !strings.HasPrefix(subscription.URL, "https://") {
+		http.Error(w, "A webhook subscription needs an http(s) URL", http.StatusBadRequest)
+		return
+	}
+	if subscription.Secret == "" {
+		http.Error(w, "A webhook subscription needs a secret", http.StatusBadRequest)
+		return
+	}
+	if subscription.MinSeverity == "" {
+		subscription.MinSeverity = "info"
+	}
+	if _, ok := alertSeverities[subscription.MinSeverity]; !ok {
+		http.Error(w, "Min severity must be info, warning or severe", http.StatusBadRequest)
+		return
+	}
+	subscription.ID = newRandomID()
+	subscription.CreatedAt = g.now(r.Context()).UTC()
+
+	stored := subscription
+	sealed, err := g.sealWebhookSecret(subscription.Secret)
+	if err != nil {
+		http.Error(w, "Error saving webhook subscription", http.StatusInternalServerError)
+		return
+	}
+	stored.Secret = sealed
+	body, err := json.Marshal(stored)
+	if err == nil {
+		err = g.cache.Set(r.Context(), webhookSubscriptionPrefix+subscription.ID, string(body), 0)
+	}
+	if err == nil {
+		err = queue.Push(r.Context(), webhookSubscriptionIDsKey, subscription.ID)
+	}
+	if err != nil {
+		http.Error(w, "Error saving webhook subscription", http.StatusInternalServerError)
+		return
+	}
+
+	subscription.Secret = ""
+	writeJSON(w, http.StatusCreated, subscription)
+}
+
+// startWebhookWorker starts sending pending deliveries and schedules the forecast re-check for webhook subscriptions
+func (g *Gateway) startWebhookWorker() {
+	queue := g.cacheQueue()
+	if queue == nil {
+		return
+	}
+
+	// Every gateway sends due deliveries, including the ones left over by a gateway that stopped
+	go func() {
+		for {
+			g.sendDueWebhooks(queue)
+			time.Sleep(webhookPollInterval)
+		}
+	}()
+
+	if g.config.WebhookSchedule == "" {
+		return
+	}
+
+	scheduler := cron.New()
+	_, err := scheduler.AddFunc(g.config.WebhookSchedule, func() {
+		// Only one gateway checks the subscriptions, so every event is queued once
+		if !g.acquireLeaderLock(context.Background(), webhookWorkerLockKey, g.config.WebhookLockTTL) {
+			return
+		}
+		g.checkWebhooks(context.Background(), queue)
+	})
+	if err != nil {
+		fmt.Println("Error scheduling the webhook worker:", err)
+		return
+	}
+
+	scheduler.Start()
+	fmt.Println("Webhook worker scheduled:", g.config.WebhookSchedule)
+}
+
+// checkWebhooks re-checks the forecast of every subscribed match and notifies subscribers
+// of material forecast changes and new alerts
+func (g *Gateway) checkWebhooks(ctx context.Context, queue Queue) {
+	subscriptions, err := g.webhookSubscriptions(ctx)
+	if err != nil {
+		fmt.Println("Error reading webhook subscriptions:", err)
+		return
+	}
+	if len(subscriptions) == 0 {
+		return
+	}
+
+	location, err := time.LoadLocation(g.config.DefaultTimezone)
+	if err != nil {
+		fmt.Println("Error loading the default timezone for the webhook worker:", err)
+		return
+	}
+	matches, err := g.upcomingMatches(ctx, g.now(ctx).In(location).Format(dateKeyLayout))
+	if err != nil {
+		fmt.Println("Error getting upcoming matches for webhooks:", err)
+		return
+	}
+
+	// Only matches someone subscribed to are looked up
+	var subscribed []Match
+	for _, match := range matches {
+		for _, subscription := range subscriptions {
+			if match.City != "" && subscription.selects(match) {
+				subscribed = append(subscribed, match)
+				break
+			}
+		}
+	}
+	forecasts := g.matchForecasts(ctx, subscribed, false)
+
+	for _, subscription := range subscriptions {
+		var events []WebhookEvent
+		state := g.webhookState(ctx, subscription.ID)
+
+		for i, forecast := range forecasts {
+			// Matches beyond the forecast horizon are notified once they have a real forecast
+			if forecast.Error != nil || forecast.AtFaceoff == nil || forecast.Source == sourceClimatology || !subscription.selects(subscribed[i]) {
+				continue
+			}
+			events = append(events, g.webhookEvents(subscription, subscribed[i], forecast, state)...)
+		}
+
+		g.saveWebhookState(ctx, subscription.ID, state)
+		g.queueWebhookEvents(ctx, queue, events)
+	}
+}
+
+// webhookEvents compares a match's forecast with what the subscriber was last told, and updates the state.
+// The first forecast of a match is recorded without an event.
+func (g *Gateway) webhookEvents(subscription WebhookSubscription, match Match, forecast WeatherForecastResponseWithInfo, state map[string]*webhookMatchState) []WebhookEvent {
+	var events []WebhookEvent
+
+	// Small changes are not recorded, so a slow drift is reported once it adds up
+	previous, seen := state[match.UID]
+	if !seen {
+		previous = &webhookMatchState{AtFaceoff: forecast.AtFaceoff}
+		state[match.UID] = previous
+	} else if materialChange(previous.AtFaceoff, forecast.AtFaceoff) {
+		events = append(events, WebhookEvent{
+			ID:             newRandomID(),
+			Type:           "forecast_changed",
+			SubscriptionID: subscription.ID,
+			Match:          match,
+			Previous:       previous.AtFaceoff,
+			Current:        forecast.AtFaceoff,
+		})
+		previous.AtFaceoff = forecast.AtFaceoff
+	}
+
+	for _, alert := range g.matchAlerts(forecast) {
+		if alertSeverities[alert.Severity] < alertSeverities[subscription.MinSeverity] || containsString(previous.Alerts, alert.Rule) {
+			continue
+		}
+		alert := alert
+		previous.Alerts = append(previous.Alerts, alert.Rule)
+		events = append(events, WebhookEvent{
+			ID:             newRandomID(),
+			Type:           "alert",
+			SubscriptionID: subscription.ID,
+			Match:          match,
+			Current:        forecast.AtFaceoff,
+			Alert:          &alert,
+		})
+	}
+	return events
+}
+
+// materialChange reports whether a faceoff forecast changed enough to notify subscribers
+func materialChange(previous, current *FaceoffWeather) bool {
+	if previous == nil || current == nil {
+		return previous != current
+	}
+	return previous.Condition != current.Condition ||
+		math.Abs(previous.TempC-current.TempC) >= materialTempChangeC ||
+		math.Abs(previous.MaxWindMPH-current.MaxWindMPH) >= materialWindChangeMPH ||
+		abs(previous.MaxChanceOfRain-current.MaxChanceOfRain) >= materialRainChancePercent
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
+
+func containsString(values []string, wanted string) bool {
+	for _, value := range values {
+		if value == wanted {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookState returns what a subscriber was last told, keyed by match UID
+func (g *Gateway) webhookState(ctx context.Context, subscriptionID string) map[string]*webhookMatchState {
+	state := make(map[string]*webhookMatchState)
+	if stored, err := g.cache.Get(ctx, webhookStatePrefix+subscriptionID); err == nil {
+		json.Unmarshal([]byte(stored), &state)
+	}
+	return state
+}
+
+func (g *Gateway) saveWebhookState(ctx context.Context, subscriptionID string, state map[string]*webhookMatchState) {
+	if body, err := json.Marshal(state); err == nil {
+		g.cache.Set(ctx, webhookStatePrefix+subscriptionID, string(body), webhookStateTTL)
+	}
+}
+
+// queueWebhookEvents stores events as pending deliveries, to be sent by the delivery loop of any gateway
+func (g *Gateway) queueWebhookEvents(ctx context.Context, queue Queue, events []WebhookEvent) {
+	for _, event := range events {
+		body, err := json.Marshal(webhookDelivery{Event: event})
+		if err == nil {
+			err = g.cache.Set(ctx, webhookDeliveryPrefix+event.ID, string(body), webhookStateTTL)
+		}
+		if err == nil {
+			err = queue.Push(ctx, webhookDeliveriesKey, event.ID)
+		}
+		if err != nil {
+			fmt.Println("Error queueing webhook delivery", event.ID+":", err)
+		}
+	}
+}
+
+// sendDueWebhooks tries every pending delivery whose next attempt is due. The deliveries and their subscriptions
+// are read in one round trip each, and the due ones are sent by a bounded pool of workers.
+func (g *Gateway) sendDueWebhooks(queue Queue) {
+	ctx := context.Background()
+	ids, err := queue.Range(ctx, webhookDeliveriesKey)
+	if err != nil {
+		fmt.Println("Error reading the webhook deliveries:", err)
+		return
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = webhookDeliveryPrefix + id
+	}
+	stored, err := g.cache.MGet(ctx, keys...)
+	if err != nil {
+		fmt.Println("Error reading the webhook deliveries:", err)
+		return
+	}
+	now := g.clock.Now().UTC()
+	var due []string
+	var subscriptionKeys []string
+	for i, entry := range stored {
+		var delivery webhookDelivery
+		if entry == "" {
+			// Another gateway finished it after it was listed, or it expired
+			queue.Remove(ctx, webhookDeliveriesKey, ids[i])
+			continue
+		}
+		if json.Unmarshal([]byte(entry), &delivery) != nil || now.Before(delivery.NextAttemptAt) {
+			continue
+		}
+		due = append(due, ids[i])
+		subscriptionKeys = append(subscriptionKeys, webhookSubscriptionPrefix+delivery.Event.SubscriptionID)
+	}
+	if len(due) == 0 {
+		return
+	}
+
+	// A subscription deleted in the meantime is missing from the map and gets nothing more
+	storedSubscriptions, err := g.cache.MGet(ctx, subscriptionKeys...)
+	if err != nil {
+		fmt.Println("Error reading the webhook subscriptions:", err)
+		return
+	}
+	subscriptions := make(map[string]WebhookSubscription)
+	for _, entry := range storedSubscriptions {
+		var subscription WebhookSubscription
+		if entry != "" && json.Unmarshal([]byte(entry), &subscription) == nil {
+			subscriptions[subscription.ID] = subscription
+		}
+	}
+
+	fanOut(ctx, len(due), webhookDeliveryWorkers, func(ctx context.Context, i int) struct{} {
+		g.sendWebhookDelivery(ctx, queue, due[i], subscriptions)
+		return struct{}{}
+	})
+}
+
+// sendWebhookDelivery makes one attempt at a pending delivery. A failed attempt is retried with exponential
+// backoff, and the delivery goes to the dead-letter list once it used up its attempts. A delivery stays
+// pending until its attempt is recorded, so a gateway stopping mid-send can make the subscriber receive it twice.
+func (g *Gateway) sendWebhookDelivery(ctx context.Context, queue Queue, id string, subscriptions map[string]WebhookSubscription) {
+	locker, ok := g.cache.(Locker)
+	if !ok {
+		return
+	}
+	lease := instanceID + "-" + newRandomID()
+	if acquired, err := locker.SetNX(ctx, webhookDeliveryLeasePrefix+id, lease, webhookDeliveryLeaseTTL); err != nil || !acquired {
+		return
+	}
+	defer locker.Release(ctx, webhookDeliveryLeasePrefix+id, lease)
+
+	var delivery webhookDelivery
+	stored, err := g.cache.Get(ctx, webhookDeliveryPrefix+id)
+	if err == nil {
+		err = json.Unmarshal([]byte(stored), &delivery)
+	}
+	if err != nil {
+		// Another gateway finished it after it was listed, or it expired
+		if err == errCacheMiss {
+			queue.Remove(ctx, webhookDeliveriesKey, id)
+		}
+		return
+	}
+	now := g.clock.Now().UTC()
+	if now.Before(delivery.NextAttemptAt) {
+		return
+	}
+
+	subscription, ok := subscriptions[delivery.Event.SubscriptionID]
+	if !ok {
+		g.finishWebhookDelivery(ctx, queue, id)
+		return
+	}
+
+	delivery.Attempts++
+	delivery.Event.SentAt = now
+	body, err := json.Marshal(delivery.Event)
+	if err == nil {
+		subscription.Secret, err = g.openWebhookSecret(subscription.Secret)
+	}
+	if err == nil {
+		sendCtx, cancel := context.WithTimeout(ctx, webhookDeliveryTimeout)
+		err = postWebhook(sendCtx, subscription, delivery.Event, body)
+		cancel()
+	}
+	if err == nil {
+		g.finishWebhookDelivery(ctx, queue, id)
+		return
+	}
+
+	if delivery.Attempts >= g.config.WebhookMaxAttempts {
+		fmt.Printf("Webhook delivery %s to %s failed after %d attempts: %v\n", id, subscription.URL, delivery.Attempts, err)
+		g.addDeadLetter(ctx, queue, DeadLetter{
+			Event:     delivery.Event,
+			URL:       subscription.URL,
+			Attempts:  delivery.Attempts,
+			LastError: err.Error(),
+			FailedAt:  now,
+		})
+		g.finishWebhookDelivery(ctx, queue, id)
+		return
+	}
+
+	delivery.LastError = err.Error()
+	delivery.NextAttemptAt = now.Add(g.config.WebhookRetryBackoff * time.Duration(1<<(delivery.Attempts-1)))
+	if body, err := json.Marshal(delivery); err == nil {
+		g.cache.Set(ctx, webhookDeliveryPrefix+id, string(body), webhookStateTTL)
+	}
+}
+
+// finishWebhookDelivery removes a delivery that needs no more attempts
+func (g *Gateway) finishWebhookDelivery(ctx context.Context, queue Queue, id string) {
+	queue.Remove(ctx, webhookDeliveriesKey, id)
+	g.cache.Delete(ctx, webhookDeliveryPrefix+id)
+}
+
+// postWebhook sends one signed event. Any status other than 2xx counts as a failure.
+func postWebhook(ctx context.Context, subscription WebhookSubscription, event WebhookEvent, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", event.Type)
+	req.Header.Set("X-Webhook-Delivery", event.ID)
+	req.Header.Set("X-Webhook-Signature", "sha256="+signWebhook(subscription.Secret, body))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("subscriber returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSendDueWebhooks(t *testing.T) {
+	ctx := context.Background()
+	g := newTestGateway(t, "2023-10-09T12:00:00Z")
+	g.config.WebhookSecretKey = "test-key"
+	queue := g.cacheQueue()
+
+	// The slow subscriber answers once the fast one was sent its event, which it never would if they were sent one by one
+	fastSent := make(chan WebhookEvent, 1)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event WebhookEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		fastSent <- event
+	}))
+	defer fast.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case event := <-fastSent:
+			fastSent <- event
+		case <-time.After(2 * time.Second):
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer slow.Close()
+
+	subscribe := func(id, url string) {
+		secret, err := g.sealWebhookSecret("s3cret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := json.Marshal(WebhookSubscription{ID: id, URL: url, Secret: secret})
+		g.cache.Set(ctx, webhookSubscriptionPrefix+id, string(body), 0)
+	}
+	subscribe("slow", slow.URL)
+	subscribe("fast", fast.URL)
+	g.queueWebhookEvents(ctx, queue, []WebhookEvent{
+		{ID: "to-slow", Type: "alert", SubscriptionID: "slow"},
+		{ID: "to-deleted", Type: "alert", SubscriptionID: "deleted"},
+		{ID: "to-fast", Type: "alert", SubscriptionID: "fast"},
+	})
+
+	g.sendDueWebhooks(queue)
+
+	select {
+	case event := <-fastSent:
+		if want := g.clock.Now().UTC(); event.SentAt.IsZero() || event.SentAt.After(want) {
+			t.Errorf("sent_at = %s, want the time of the attempt, before %s", event.SentAt, want)
+		}
+	default:
+		t.Fatal("fast subscriber was not sent its event")
+	}
+	if ids, err := queue.Range(ctx, webhookDeliveriesKey); err != nil || len(ids) != 0 {
+		t.Errorf("pending deliveries = %q, %v; want none", ids, err)
+	}
+}
@@ -13,9 +13,10 @@ app.config['TIMEOUT'] = 5
 matches_counter = Counter('matches_requests', 'Total number of requests per endpoint', ['endpoint'])
 
 
-def get_upcoming_matches():
+def get_upcoming_matches(target_date=None):
     try:
-        today_date = datetime.today()
+        # The gateway passes the date in the client's timezone, otherwise the server's date is used
+        today_date = datetime.strptime(target_date, '%Y-%m-%d') if target_date else datetime.today()
         year, month, day = today_date.year, today_date.month, today_date.day
 
         conn = http.client.HTTPSConnection("nhl-api5.p.rapidapi.com")
@@ -50,6 +51,7 @@ def get_upcoming_matches():
 
                     game_info = {
                         'date': date,
+                        'start_time': date_str,
                         'uid': uid,
                         'name': game.get('name'),
                         'venue_full_name': venue_info.get('fullName', ''),
@@ -66,8 +68,10 @@ def get_upcoming_matches():
         return {'error_message': str(e), 'status_code': 500}
 
 
-def get_today_matches():
-    today_date = datetime.today().strftime('%Y-%m-%d')
+def get_today_matches(today_date=None):
+    # The gateway passes the date in the client's timezone, otherwise the server's date is used
+    if not today_date:
+        today_date = datetime.today().strftime('%Y-%m-%d')
 
     conn = http.client.HTTPSConnection("nhl-api5.p.rapidapi.com")
 
@@ -100,6 +104,7 @@ def get_today_matches():
 
                 match_info = {
                     'date': date,
+                    'start_time': date_str,
                     'uid': uid,
                     'name': game_info.get('name', ''),
                     'venue_full_name': venue_info.get('fullName', ''),
@@ -147,6 +152,7 @@ def get_past_matches_on_date(target_date):
         date = datetime.strptime(date_str, "%Y-%m-%dT%H:%MZ").strftime("%d.%m.%Y")
         event_data = {
             'date': date,
+            'start_time': date_str,
             'uid': uid,
             'name': event['name'],
             'venue_full_name': event['competitions'][0]['venue']['fullName'],
@@ -334,7 +340,7 @@ def upcoming_matches():
     matches_counter.labels(endpoint='upcoming_matches').inc()
     try:
         # Call the get_upcoming_matches function
-        matches_info = get_upcoming_matches()
+        matches_info = get_upcoming_matches(request.args.get('date'))
         return jsonify(matches_info)
     except Exception as e:
         return jsonify({'error': str(e)}), 500
@@ -346,8 +352,7 @@ def today_matches():
 
     try:
         # Call the get_today_matches function
-        matches_info = get_today_matches()
-        matches_info = get_today_matches()
+        matches_info = get_today_matches(request.args.get('date'))
         return jsonify(matches_info)
     except Exception as e:
         return jsonify({'error': str(e)}), 500
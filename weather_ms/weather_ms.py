@@ -5,6 +5,7 @@ import psycopg2
 import json
 import http.client
 from datetime import datetime, timezone
+from urllib.parse import quote
 from prometheus_client import Counter, Gauge, generate_latest
 
 
@@ -40,7 +41,7 @@ def get_weather_forecast(location, date):
         'X-RapidAPI-Host': "weatherapi-com.p.rapidapi.com"
     }
 
-    conn.request("GET", f"/forecast.json?q={location}&days=1&dt={date_str}", headers=headers)
+    conn.request("GET", f"/forecast.json?q={quote(location)}&days=1&dt={date_str}", headers=headers)
 
     res = conn.getresponse()
     data = res.read()
@@ -83,7 +84,7 @@ def get_current_weather(city):
         'X-RapidAPI-Host': "weatherapi-com.p.rapidapi.com"
     }
 
-    conn.request("GET", f"/current.json?q={city}", headers=headers)
+    conn.request("GET", f"/current.json?q={quote(city)}", headers=headers)
 
     res = conn.getresponse()
     data = res.read()
@@ -128,7 +129,7 @@ def get_weather_history_by_location_date(location, date):
     date_iso = datetime(year, month, day).isoformat()
     date_str = date_iso.split('T')[0]  # Extracting only the date part
 
-    conn.request("GET", f"/history.json?q={location}&dt={date_str}", headers=headers)
+    conn.request("GET", f"/history.json?q={quote(location)}&dt={date_str}", headers=headers)
 
     res = conn.getresponse()
     data = res.read()
@@ -175,7 +176,7 @@ def get_astro(city, date):
         'X-RapidAPI-Host': "weatherapi-com.p.rapidapi.com"
     }
 
-    conn.request("GET", f"/astronomy.json?q={city}&dt={date}", headers=headers)
+    conn.request("GET", f"/astronomy.json?q={quote(city)}&dt={date}", headers=headers)
 
     res = conn.getresponse()
     data = res.read()